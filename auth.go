@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var ErrAuth = errors.New("authentication failed")
+
+// auth are the credentials for iperf3 servers that require authentication. The password is
+// read from a file and passed to iperf3 through its environment, so it never shows up in
+// the config or on the command line. The username is passed with --username and can be
+// set inline or read from a file. It gets redacted in the logs.
+type auth struct {
+	Username     string
+	UsernameFile string `mapstructure:"username_file"`
+	PasswordFile string `mapstructure:"password_file"`
+	PublicKey    string `mapstructure:"rsa_public_key_path"`
+}
+
+// set reports if the credentials are configured.
+func (a auth) set() bool {
+	return a.Username != "" || a.UsernameFile != ""
+}
+
+// or returns the credentials with every unset option taken from the fallback.
+func (a auth) or(fallback auth) auth {
+	if a.Username == "" && a.UsernameFile == "" {
+		a.Username, a.UsernameFile = fallback.Username, fallback.UsernameFile
+	}
+
+	if a.PasswordFile == "" {
+		a.PasswordFile = fallback.PasswordFile
+	}
+
+	if a.PublicKey == "" {
+		a.PublicKey = fallback.PublicKey
+	}
+
+	return a
+}
+
+// readSecret reads a secret from a file and strips surrounding whitespace.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: could not read secret: %s", ErrAuth, err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// credentials returns the username and the password. The files are read every time, so
+// that rotated credentials get picked up.
+func (a auth) credentials() (string, string, error) {
+	if !a.set() {
+		return "", "", nil
+	}
+
+	if a.PasswordFile == "" || a.PublicKey == "" {
+		return "", "", fmt.Errorf("%w: password file and public key are needed", ErrAuth)
+	}
+
+	username := a.Username
+
+	if username == "" {
+		var err error
+
+		username, err = readSecret(a.UsernameFile)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	password, err := readSecret(a.PasswordFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+// redacted replaces credentials in the logs.
+const redacted = "<redacted>"
+
+// redactCommand returns the command line of cmd for the logs, with the username redacted.
+func redactCommand(cmd *exec.Cmd) string {
+	args := append([]string{cmd.Path}, cmd.Args[1:]...)
+
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "--username" {
+			args[i] = redacted
+		}
+	}
+
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,80 @@
+package main //nolint:testpackage
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthCredentials(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	userFile := filepath.Join(dir, "username")
+	passFile := filepath.Join(dir, "password")
+
+	require.NoError(t, os.WriteFile(userFile, []byte("prometheus\n"), 0o600))
+	require.NoError(t, os.WriteFile(passFile, []byte("s3cr3t\n"), 0o600))
+
+	tables := []struct {
+		name     string
+		a        auth
+		username string
+		password string
+		err      error
+	}{
+		{"001", auth{}, "", "", nil},
+		{"002", auth{Username: "foo", PasswordFile: passFile, PublicKey: "/key.pem"}, "foo", "s3cr3t", nil},
+		{"003", auth{UsernameFile: userFile, PasswordFile: passFile, PublicKey: "/key.pem"}, "prometheus", "s3cr3t", nil},
+		{"004", auth{Username: "foo", PasswordFile: filepath.Join(dir, "missing"), PublicKey: "/key.pem"}, "", "", ErrAuth},
+		{
+			"005",
+			auth{UsernameFile: filepath.Join(dir, "missing"), PasswordFile: passFile, PublicKey: "/key.pem"},
+			"",
+			"",
+			ErrAuth,
+		},
+		{"006", auth{Username: "foo", PublicKey: "/key.pem"}, "", "", ErrAuth},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+			username, password, err := table.a.credentials()
+			require.ErrorIs(err, table.err)
+			require.Equal(table.username, username)
+			require.Equal(table.password, password)
+		})
+	}
+}
+
+func TestAuthOr(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	global := auth{Username: "global", PasswordFile: "/global", PublicKey: "/key.pem"}
+	target := auth{UsernameFile: "/username", PasswordFile: "/target"}
+
+	require.Equal(global, auth{}.or(global))
+	require.Equal(auth{UsernameFile: "/username", PasswordFile: "/target", PublicKey: "/key.pem"}, target.or(global))
+	require.Equal(
+		auth{Username: "module", PasswordFile: "/global", PublicKey: "/key.pem"},
+		auth{Username: "module"}.or(global),
+	)
+}
+
+func TestRedactCommand(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	cmd := exec.Command("/usr/bin/iperf3", "-J", "-c", "iperf.tld", "--username", "prometheus", "-R")
+
+	s := redactCommand(cmd)
+	require.Equal("/usr/bin/iperf3 -J -c iperf.tld --username <redacted> -R", s)
+	require.NotContains(s, "prometheus")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog"
+)
+
+// Defaults of the UDP capacity search.
+const (
+	defaultCapacityMin   = 1000000
+	defaultCapacityMax   = 1000000000
+	defaultCapacitySteps = 6
+	defaultMaxLoss       = 1
+)
+
+// udpCapacity is the result of a UDP capacity search.
+type udpCapacity struct {
+	// BitsPerSecond is the highest bitrate that stayed within the loss threshold.
+	BitsPerSecond float64
+
+	// Runs is the number of iperf3 runs of the search.
+	Runs int
+}
+
+// capacitySteps returns the number of iperf3 runs of a UDP capacity search.
+func (m module) capacitySteps() int {
+	if m.CapacitySteps == 0 {
+		return defaultCapacitySteps
+	}
+
+	return m.CapacitySteps
+}
+
+// capacityBounds returns the lowest and the highest bitrate of a UDP capacity search.
+func (m module) capacityBounds() (float64, float64) {
+	lo, hi := m.CapacityMin, m.CapacityMax
+	if lo == 0 {
+		lo = defaultCapacityMin
+	}
+
+	if hi == 0 {
+		hi = defaultCapacityMax
+	}
+
+	return lo, hi
+}
+
+// maxLoss returns the loss in percent a UDP capacity search accepts.
+func (m module) maxLoss() float64 {
+	if m.MaxLoss == 0 {
+		return defaultMaxLoss
+	}
+
+	return m.MaxLoss
+}
+
+// searchCapacity binary searches the highest UDP bitrate between the bounds of the module
+// that stays within its loss threshold. Every step is a short run at a bitrate. If no
+// bitrate passed before the last step, it tries the lower bound. The search stops early
+// once the context is done. It returns the result of the run at the highest bitrate that
+// passed, with the capacity attached. If none passed, the capacity is 0 and the result is
+// the one of the lowest bitrate tried.
+func searchCapacity(
+	ctx context.Context,
+	m module,
+	run func(ctx context.Context, rate float64) (iperfResult, error),
+	logger zerolog.Logger,
+) (iperfResult, error) {
+	lo, hi := m.capacityBounds()
+	maxLoss := m.maxLoss()
+	steps := m.capacitySteps()
+
+	var (
+		best     iperfResult
+		passed   bool
+		lowest   iperfResult
+		lastErr  error
+		capacity udpCapacity
+	)
+
+	// The upper bound is tried first, the path might just sustain it.
+	rate := hi
+
+	for i := 0; i < steps; i++ {
+		if ctx.Err() != nil {
+			logger.Warn().Int("runs", capacity.Runs).Msg("no time left for more capacity search steps")
+
+			break
+		}
+
+		if i > 0 && i == steps-1 && !passed {
+			rate = lo
+		}
+
+		r, err := run(ctx, rate)
+		if err != nil {
+			lastErr = err
+
+			break
+		}
+
+		capacity.Runs++
+		lowest = r
+
+		loss := r.End.Sum.LostPercent
+
+		logger.Debug().Float64("rate", rate).Float64("loss", loss).Msg("capacity search step")
+
+		if loss <= maxLoss && !r.Partial {
+			capacity.BitsPerSecond = rate
+			best = r
+			passed = true
+			lo = rate
+		} else {
+			hi = rate
+		}
+
+		if lo >= hi {
+			break
+		}
+
+		rate = lo + (hi-lo)/2 //nolint:gomnd
+	}
+
+	if capacity.Runs == 0 {
+		return iperfResult{}, fmt.Errorf("could not search udp capacity: %w", lastErr)
+	}
+
+	if !passed {
+		logger.Warn().Float64("max_loss", maxLoss).Msg("no bitrate within the loss threshold")
+
+		best = lowest
+	}
+
+	best.Capacity = &capacity
+
+	return best, nil
+}
+
+// capacityPhase returns the run of a phase that searches the UDP capacity in the direction.
+func capacityPhase(
+	direction string,
+	cmdArgs []string,
+) func(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
+	return func(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
+		run := func(ctx context.Context, rate float64) (iperfResult, error) {
+			args := append([]string{"-u", "-b", strconv.FormatFloat(rate, 'f', 0, 64)}, cmdArgs...)
+
+			return runIperf(ctx, p.Target, p.Module, args, logger)
+		}
+
+		r, err := searchCapacity(ctx, p.Module, run, logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s capacity: %w", direction, err)
+		}
+
+		return results{direction: r}, nil
+	}
+}
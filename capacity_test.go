@@ -0,0 +1,70 @@
+package main //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchCapacity(t *testing.T) {
+	t.Parallel()
+
+	errRun := errors.New("run failed")
+
+	tables := []struct {
+		name     string
+		m        module
+		capacity float64
+		loss     float64
+		runs     int
+		fail     int
+		err      error
+	}{
+		{"001", module{CapacityMin: 100, CapacityMax: 300, CapacitySteps: 4}, 300, 0, 1, 0, nil},
+		{"002", module{CapacityMin: 100, CapacityMax: 400, CapacitySteps: 4}, 287.5, 0, 4, 0, nil},
+		{"003", module{CapacityMin: 100, CapacityMax: 400, CapacitySteps: 4, MaxLoss: 10}, 400, 5, 1, 0, nil},
+		{"004", module{CapacityMin: 100, CapacityMax: 400, CapacitySteps: 4}, 250, 0, 2, 3, nil},
+		{"005", module{CapacityMin: 100, CapacityMax: 400, CapacitySteps: 4}, 0, 0, 0, 1, errRun},
+		{"006", module{CapacityMin: 300, CapacityMax: 400, CapacitySteps: 3}, 300, 0, 3, 0, nil},
+		{"007", module{CapacityMin: 350, CapacityMax: 400, CapacitySteps: 3}, 0, 5, 3, 0, nil},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			var runs int
+
+			// The path loses 5 percent above 300 bit/s. fail lets the runs fail from then on.
+			run := func(ctx context.Context, rate float64) (iperfResult, error) {
+				runs++
+				if table.fail != 0 && runs >= table.fail {
+					return iperfResult{}, errRun
+				}
+
+				var r iperfResult
+				if rate > 300 {
+					r.End.Sum.LostPercent = 5
+				}
+
+				return r, nil
+			}
+
+			r, err := searchCapacity(context.Background(), table.m, run, zerolog.Nop())
+			require.ErrorIs(err, table.err)
+
+			if table.err != nil {
+				return
+			}
+
+			require.Equal(table.capacity, r.Capacity.BitsPerSecond)
+			require.Equal(table.loss, r.End.Sum.LostPercent)
+			require.Equal(table.runs, r.Capacity.Runs)
+		})
+	}
+}
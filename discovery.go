@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultSRVRefresh is the refresh interval of DNS SRV discovery if none is configured.
+const defaultSRVRefresh = 5 * time.Minute
+
+// fileSDConfig configures a file in the prometheus `file_sd` format to read targets from.
+type fileSDConfig struct {
+	Path   string `validate:"required"`
+	Module string
+}
+
+// srvSDConfig configures a DNS SRV record to look up targets from.
+type srvSDConfig struct {
+	Name    string `validate:"required"`
+	Module  string
+	Refresh time.Duration `validate:"gte=0"`
+}
+
+// fileSDGroup is a target group in the prometheus `file_sd` format.
+type fileSDGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// source returns the registry source name of the file, which is also used as `source` label.
+func (f fileSDConfig) source() string {
+	return "file:" + f.Path
+}
+
+// source returns the registry source name of the record, which is also used as `source` label.
+func (s srvSDConfig) source() string {
+	return "dns_srv:" + s.Name
+}
+
+// parseFileSD creates targets out of JSON or YAML data in the prometheus `file_sd` format.
+// Every address is a target named after itself. A `__param_module` label overrides the
+// module of the file config, all other labels starting with `__` are dropped. Files with
+// invalid label names are rejected.
+func parseFileSD(data []byte, f fileSDConfig) ([]targetConfig, error) {
+	var groups []fileSDGroup
+
+	// JSON is valid YAML, so the YAML parser handles both formats.
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("could not unmarshal file: %w", err)
+	}
+
+	var ts []targetConfig
+
+	for _, g := range groups {
+		m := f.Module
+		l := map[string]string{"source": f.source()}
+
+		for k, v := range g.Labels {
+			switch {
+			case k == "__param_module":
+				m = v
+			case strings.HasPrefix(k, "__"):
+			case !validLabelName(k), reservedLabelNames[k]:
+				return nil, fmt.Errorf("label %q: %w", k, ErrInvalidLabelName)
+			default:
+				l[k] = v
+			}
+		}
+
+		for _, a := range g.Targets {
+			ts = append(ts, targetConfig{
+				Name:    a,
+				Address: a,
+				Module:  m,
+				Labels:  l,
+			})
+		}
+	}
+
+	return ts, nil
+}
+
+// srvTargets creates targets out of looked up SRV records.
+func srvTargets(s srvSDConfig, srvs []*net.SRV) []targetConfig {
+	ts := make([]targetConfig, 0, len(srvs))
+
+	for _, srv := range srvs {
+		a := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprintf("%d", srv.Port))
+
+		ts = append(ts, targetConfig{
+			Name:    a,
+			Address: a,
+			Module:  s.Module,
+			Labels:  map[string]string{"source": s.source()},
+		})
+	}
+
+	return ts
+}
+
+// loadFileSD reads the targets of a file into the registry.
+func loadFileSD(f fileSDConfig) {
+	logger := log.With().Str("source", f.source()).Logger()
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		logger.Error().Err(err).Msg("could not read file")
+
+		return
+	}
+
+	ts, err := parseFileSD(data, f)
+	if err != nil {
+		logger.Error().Err(err).Msg("could not parse file")
+
+		return
+	}
+
+	targets.update(f.source(), ts)
+	logger.Info().Int("targets", len(ts)).Msg("loaded targets")
+}
+
+// watchFileSD loads the targets of a file and reloads them on every change. It watches the
+// directory of the file, because many tools replace files instead of writing to them.
+func watchFileSD(ctx context.Context, f fileSDConfig) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not create watcher: %w", err)
+	}
+
+	if err := w.Add(filepath.Dir(f.Path)); err != nil {
+		w.Close()
+
+		return fmt.Errorf("could not watch %s: %w", f.Path, err)
+	}
+
+	loadFileSD(f)
+
+	go func() {
+		defer w.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-w.Events:
+				if filepath.Clean(e.Name) == filepath.Clean(f.Path) {
+					loadFileSD(f)
+				}
+			case err := <-w.Errors:
+				log.Error().Err(err).Str("source", f.source()).Msg("error while watching file")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// lookupSRV looks up the targets of a SRV record into the registry.
+func lookupSRV(ctx context.Context, s srvSDConfig) {
+	logger := log.With().Str("source", s.source()).Logger()
+
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		logger.Error().Err(err).Msg("could not look up srv record")
+
+		return
+	}
+
+	ts := srvTargets(s, srvs)
+
+	targets.update(s.source(), ts)
+	logger.Debug().Int("targets", len(ts)).Msg("looked up targets")
+}
+
+// refreshSRV looks up the targets of a SRV record in the configured refresh interval.
+func refreshSRV(ctx context.Context, s srvSDConfig) {
+	refresh := s.Refresh
+	if refresh == 0 {
+		refresh = defaultSRVRefresh
+	}
+
+	lookupSRV(ctx, s)
+
+	go func() {
+		t := time.NewTicker(refresh)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				lookupSRV(ctx, s)
+			}
+		}
+	}()
+}
+
+// startDiscovery fills the target registry with the static targets and starts all
+// configured discovery sources. Targets and sources that refer to a module that is not
+// configured are rejected.
+func startDiscovery(ctx context.Context) error {
+	if err := checkTargetModules(c.Targets, c.Modules); err != nil {
+		return err
+	}
+
+	for _, f := range c.Discovery.Files {
+		if err := knownModule(f.Module, c.Modules); err != nil {
+			return fmt.Errorf("file %s: %w", f.Path, err)
+		}
+	}
+
+	for _, s := range c.Discovery.DNSSRV {
+		if err := knownModule(s.Module, c.Modules); err != nil {
+			return fmt.Errorf("srv record %s: %w", s.Name, err)
+		}
+	}
+
+	targets.update(staticSource, c.Targets)
+
+	for _, f := range c.Discovery.Files {
+		if err := watchFileSD(ctx, f); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.Discovery.DNSSRV {
+		refreshSRV(ctx, s)
+	}
+
+	return nil
+}
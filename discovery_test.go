@@ -0,0 +1,98 @@
+package main //nolint:testpackage
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFileSD(t *testing.T) {
+	t.Parallel()
+
+	f := fileSDConfig{Path: "/etc/iperf3exporter/targets.yml", Module: "wan"}
+	src := map[string]string{"source": "file:/etc/iperf3exporter/targets.yml"}
+
+	tables := []struct {
+		name     string
+		data     string
+		expected []targetConfig
+		err      error
+	}{
+		{
+			"001",
+			`[{"targets": ["speedtest.wobcom.de", "footest.bar.tld:1234"]}]`,
+			[]targetConfig{
+				{Name: "speedtest.wobcom.de", Address: "speedtest.wobcom.de", Module: "wan", Labels: src},
+				{Name: "footest.bar.tld:1234", Address: "footest.bar.tld:1234", Module: "wan", Labels: src},
+			},
+			nil,
+		},
+		{
+			"002",
+			`
+- targets:
+    - iperf.office.tld
+  labels:
+    __param_module: lan
+    __meta_foo: bar
+    site: office
+`,
+			[]targetConfig{
+				{
+					Name:    "iperf.office.tld",
+					Address: "iperf.office.tld",
+					Module:  "lan",
+					Labels: map[string]string{
+						"source": "file:/etc/iperf3exporter/targets.yml",
+						"site":   "office",
+					},
+				},
+			},
+			nil,
+		},
+		{
+			"003",
+			`[{"targets": ["speedtest.wobcom.de"], "labels": {"my-site": "office"}}]`,
+			nil,
+			ErrInvalidLabelName,
+		},
+		{
+			"004",
+			`[{"targets": ["speedtest.wobcom.de"], "labels": {"direction": "upload"}}]`,
+			nil,
+			ErrInvalidLabelName,
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+			ts, err := parseFileSD([]byte(table.data), f)
+			require.ErrorIs(err, table.err)
+			require.Equal(table.expected, ts)
+		})
+	}
+}
+
+func TestSRVTargets(t *testing.T) {
+	t.Parallel()
+
+	s := srvSDConfig{Name: "_iperf3._tcp.example.com", Module: "wan"}
+	ts := srvTargets(s, []*net.SRV{{Target: "iperf1.example.com.", Port: 5201}})
+
+	require.Equal(
+		t,
+		[]targetConfig{
+			{
+				Name:    "iperf1.example.com:5201",
+				Address: "iperf1.example.com:5201",
+				Module:  "wan",
+				Labels:  map[string]string{"source": "dns_srv:_iperf3._tcp.example.com"},
+			},
+		},
+		ts,
+	)
+}
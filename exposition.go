@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// metricPrefix is the prefix of the metric names of the exporter.
+const metricPrefix = "iperf3_"
+
+// metricPrefixRe matches valid metric name prefixes.
+var metricPrefixRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// validMetricPrefix is the validator of the metric_prefix tag.
+func validMetricPrefix(fl validator.FieldLevel) bool {
+	return metricPrefixRe.MatchString(fl.Field().String())
+}
+
+// labelNameRe matches valid label names.
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ErrInvalidLabelName is returned for label names prometheus does not accept.
+var ErrInvalidLabelName = errors.New("invalid label name")
+
+// validLabelName reports if name is a valid label name. Names starting with `__` are
+// reserved for prometheus.
+func validLabelName(name string) bool {
+	return labelNameRe.MatchString(name) && !strings.HasPrefix(name, "__")
+}
+
+// validLabelNameTag is the validator of the label_name tag.
+func validLabelNameTag(fl validator.FieldLevel) bool {
+	return validLabelName(fl.Field().String())
+}
+
+// reservedLabelNames are the label names the exporter sets itself. Constant and target
+// labels can not use them, the labels of the exporter would replace them on some series.
+var reservedLabelNames = map[string]bool{ //nolint:gochecknoglobals
+	"candidate":  true,
+	"congestion": true,
+	"direction":  true,
+	"dscp":       true,
+	"partial":    true,
+	"pool":       true,
+	"quantile":   true,
+	"reason":     true,
+	"role":       true,
+	"server":     true,
+	"side":       true,
+	"step":       true,
+	"version":    true,
+}
+
+// unreservedLabelNameTag is the validator of the unreserved_label_name tag.
+func unreservedLabelNameTag(fl validator.FieldLevel) bool {
+	return !reservedLabelNames[fl.Field().String()]
+}
+
+// format is the exposition format of the metrics.
+type format int
+
+const (
+	formatText format = iota
+	formatOpenMetrics
+)
+
+// negotiateFormat returns the exposition format the scraper asked for in its Accept header.
+// OpenMetrics is used if the scraper prefers it over the text format, or accepts both alike.
+func negotiateFormat(r *http.Request) format {
+	var openMetrics, text float64
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			switch mediaType {
+			case "application/openmetrics-text":
+				openMetrics = math.Max(openMetrics, q)
+			case "text/plain", "text/*", "*/*":
+				text = math.Max(text, q)
+			}
+		}
+	}
+
+	if openMetrics > 0 && openMetrics >= text {
+		return formatOpenMetrics
+	}
+
+	return formatText
+}
+
+// contentType returns the content type of the format.
+func (f format) contentType() string {
+	if f == formatOpenMetrics {
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	}
+
+	return "text/plain; version=0.0.4; charset=utf-8"
+}
+
+// timestamp formats t as sample timestamp. The text format has milliseconds, OpenMetrics
+// seconds.
+func (f format) timestamp(t time.Time) string {
+	ms := t.UnixNano() / int64(time.Millisecond)
+
+	if f == formatOpenMetrics {
+		return fmt.Sprintf("%d.%03d", ms/1000, ms%1000) //nolint:gomnd
+	}
+
+	return fmt.Sprintf("%d", ms)
+}
+
+// escapeHelp escapes the help text of a metric family. OpenMetrics escapes double quotes as
+// well.
+func (f format) escapeHelp(help string) string {
+	if f == formatOpenMetrics {
+		return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(help)
+	}
+
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// metricType returns the type of the metric family in the format. OpenMetrics counters need
+// the `_total` suffix. Counters without it are unknown there, to keep their series names the
+// same in both formats.
+func (f format) metricType(name string, d description) string {
+	if f == formatOpenMetrics && d.Type == "counter" && !strings.HasSuffix(name, "_total") {
+		return "unknown"
+	}
+
+	return d.Type
+}
+
+// description is the type and help of a metric family.
+type description struct {
+	Type string
+	Help string
+}
+
+// descriptions of the metric families of the exporter.
+var descriptions = map[string]description{ //nolint:gochecknoglobals
+	"iperf3_bits_per_second":              {"gauge", "Throughput of the run, as seen by the sender or the receiver."},
+	"iperf3_bytes":                        {"gauge", "Bytes of the run, as seen by the sender or the receiver."},
+	"iperf3_duration_seconds":             {"gauge", "Duration of the run, as seen by the sender or the receiver."},
+	"iperf3_retransmits":                  {"gauge", "TCP retransmits of the sender in the run."},
+	"iperf3_jitter_seconds":               {"gauge", "Jitter of a UDP run."},
+	"iperf3_lost_packets":                 {"gauge", "Lost packets of a UDP run."},
+	"iperf3_packets":                      {"gauge", "Packets of a UDP run."},
+	"iperf3_lost_percent":                 {"gauge", "Lost packets of a UDP run in percent."},
+	"iperf3_omitted_seconds":              {"gauge", "Seconds at the start of the run that were omitted."},
+	"iperf3_bits_per_second_with_omitted": {"gauge", "Throughput of the run, including the omitted seconds."},
+	"iperf3_server_bits_per_second":       {"gauge", "Throughput of the run, as reported by the server."},
+	"iperf3_server_bytes":                 {"gauge", "Bytes transferred in the run, as reported by the server."},
+	"iperf3_cpu_utilization_percent":      {"gauge", "CPU utilization of the client or the server during the run."},
+	"iperf3_interval_bits_per_second":     {"gauge", "Throughput of an interval of the run, at the end of the interval."},
+	"iperf3_connect_failures":             {"gauge", "Failed TCP connects to the iperf3 server before the probe."},
+	"iperf3_connect_min_seconds":          {"gauge", "Fastest TCP connect to the iperf3 server before the probe."},
+	"iperf3_connect_avg_seconds":          {"gauge", "Average TCP connect time to the iperf3 server before the probe."},
+	"iperf3_connect_max_seconds":          {"gauge", "Slowest TCP connect to the iperf3 server before the probe."},
+	"iperf3_latency_idle_seconds":         {"gauge", "TCP connect time to the latency target on the idle link."},
+	"iperf3_latency_loaded_seconds":       {"gauge", "TCP connect time to the latency target during the run."},
+	"iperf3_latency_loaded_failures":      {"gauge", "Failed TCP connects to the latency target during the run."},
+	"iperf3_latency_increase_seconds":     {"gauge", "Increase of the TCP connect time to the latency target under load."},
+	"iperf3_phase_skipped":                {"gauge", "Whether the phase of the probe got skipped."},
+	"iperf3_repetitions_completed":        {"gauge", "Completed repetitions of the phase."},
+	"iperf3_repetition_bits_per_second":   {"gauge", "Quantiles of the received throughput of all repetitions."},
+	"iperf3_udp_capacity_bits_per_second": {"gauge", "Highest UDP bitrate within the loss threshold."},
+	"iperf3_udp_capacity_lost_percent":    {"gauge", "Lost packets at the UDP capacity in percent."},
+	"iperf3_udp_capacity_jitter_seconds":  {"gauge", "Jitter at the UDP capacity."},
+	"iperf3_udp_capacity_runs":            {"gauge", "Runs of the UDP capacity search."},
+	"iperf3_auto_candidate_reachable":     {"gauge", "Whether the pool server was reachable."},
+	"iperf3_auto_connect_seconds":         {"gauge", "TCP connect time to the pool server."},
+	"iperf3_errors":                       {"counter", "Failed probes by reason."},
+	"iperf3_binary_info":                  {"gauge", "Version of the iperf3 binary."},
+	"iperf3_pool_server_available":        {"gauge", "Whether the pool server is not in its cooldown."},
+	"iperf3_pool_server_successes":        {"counter", "Successful probes of the pool server."},
+	"iperf3_pool_server_failures":         {"counter", "Failed probes of the pool server by reason."},
+}
+
+// exposition is how the metrics get exposed. Prefix replaces the `iperf3_` prefix of the
+// metric names and Labels get added to every series.
+type exposition struct {
+	Format format
+	Prefix string
+	Labels labels
+}
+
+// rename replaces the `iperf3_` prefix of the metric name with the prefix of the exposition.
+func (e exposition) rename(name string) string {
+	if e.Prefix == "" || !strings.HasPrefix(name, metricPrefix) {
+		return name
+	}
+
+	return e.Prefix + strings.TrimPrefix(name, metricPrefix)
+}
+
+// describe returns the description of the metric family. Legacy per direction families
+// point to their replacement.
+func (e exposition) describe(name string) (description, bool) {
+	if d, ok := descriptions[name]; ok {
+		return d, true
+	}
+
+	for _, direction := range []string{"download", "upload"} {
+		legacy := strings.TrimPrefix(name, metricPrefix+direction+"_")
+		if legacy == name {
+			continue
+		}
+
+		if n, ok := legacyNames[legacy]; ok {
+			help := fmt.Sprintf("Deprecated, use %s%s.", e.rename(n.Family), n.Labels.with("direction", direction))
+
+			return description{"gauge", help}, true
+		}
+	}
+
+	return description{}, false
+}
+
+// ErrInvalidSample is returned for sample lines that are not in the prometheus text format.
+var ErrInvalidSample = errors.New("invalid sample")
+
+// sample is a sample line of the prometheus text format.
+type sample struct {
+	Name   string
+	Labels labels
+
+	// Value is the value of the sample, followed by its timestamp if it has one.
+	Value string
+}
+
+// parseSample parses a sample line of the prometheus text format. Whitespace around the
+// labels is accepted, the VictoriaMetrics metrics write some with it.
+func parseSample(line string) (sample, error) {
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		return sample{}, fmt.Errorf("%w: %q", ErrInvalidSample, line)
+	}
+
+	s := sample{Name: line[:i], Labels: labels{}}
+	rest := line[i:]
+
+	if strings.HasPrefix(rest, "{") {
+		var ok bool
+
+		rest, ok = parseLabels(rest[1:], s.Labels)
+		if !ok {
+			return sample{}, fmt.Errorf("%w: %q", ErrInvalidSample, line)
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample{}, fmt.Errorf("%w: %q", ErrInvalidSample, line)
+	}
+
+	s.Value = strings.Join(fields, " ")
+
+	return s, nil
+}
+
+// parseLabels parses the labels of a sample line after the opening brace into l. It returns
+// the rest of the line after the closing brace.
+func parseLabels(s string, l labels) (string, bool) {
+	for {
+		s = strings.TrimLeft(s, " ")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], true
+		}
+
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return "", false
+		}
+
+		name := strings.TrimSpace(s[:i])
+
+		s = strings.TrimLeft(s[i+1:], " ")
+		if !strings.HasPrefix(s, `"`) {
+			return "", false
+		}
+
+		var (
+			v strings.Builder
+			j = 1
+		)
+
+		for ; j < len(s) && s[j] != '"'; j++ {
+			if s[j] == '\\' && j+1 < len(s) {
+				j++
+
+				if s[j] == 'n' {
+					v.WriteByte('\n')
+				} else {
+					v.WriteByte(s[j])
+				}
+
+				continue
+			}
+
+			v.WriteByte(s[j])
+		}
+
+		if j == len(s) {
+			return "", false
+		}
+
+		l[name] = v.String()
+		s = strings.TrimPrefix(strings.TrimLeft(s[j+1:], " "), ",")
+	}
+}
+
+// sample formats the sample, renamed and with the constant labels, without any whitespace
+// in its labels. Labels the sample already has win over the constant ones.
+func (e exposition) sample(s sample) string {
+	l := s.Labels
+
+	for k, v := range e.Labels {
+		if _, ok := l[k]; !ok {
+			l = l.with(k, v)
+		}
+	}
+
+	return e.rename(s.Name) + l.String() + " " + s.Value
+}
+
+// family is a metric family with its samples.
+type family struct {
+	Name    string
+	Samples []sample
+}
+
+// parseFamilies groups the samples of the prometheus text format by their metric family.
+// The families keep the order they first show up in. Lines that are no valid samples get
+// dropped.
+func parseFamilies(text []byte) []*family {
+	var (
+		families []*family
+		byName   = map[string]*family{}
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		smp, err := parseSample(line)
+		if err != nil {
+			continue
+		}
+
+		f, ok := byName[smp.Name]
+		if !ok {
+			f = &family{Name: smp.Name}
+			byName[smp.Name] = f
+			families = append(families, f)
+		}
+
+		f.Samples = append(f.Samples, smp)
+	}
+
+	return families
+}
+
+// write writes the samples of the prometheus text format grouped by metric family to w,
+// renamed and with the constant labels, in the format of the exposition. Every sample gets
+// rebuilt, so its labels are sorted and escaped the same way in both formats. Known
+// families get their HELP and TYPE lines.
+func (e exposition) write(w io.Writer, text []byte) {
+	for _, f := range parseFamilies(text) {
+		name := e.rename(f.Name)
+
+		if d, ok := e.describe(f.Name); ok {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, e.Format.escapeHelp(d.Help))
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, e.Format.metricType(name, d))
+		}
+
+		for _, s := range f.Samples {
+			fmt.Fprintln(w, e.sample(s))
+		}
+	}
+
+	if e.Format == formatOpenMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
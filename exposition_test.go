@@ -0,0 +1,212 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteExposition(t *testing.T) { //nolint:lll
+	t.Parallel()
+
+	text := `iperf3_bits_per_second{direction="download",role="receiver"} 9e+08
+iperf3_download_sent_bits_per_second 9e+08
+go_goroutines 8
+iperf3_errors{reason="busy"} 2
+iperf3_bits_per_second{direction="download",role="sender"} 9.1e+08
+`
+
+	tables := []struct {
+		name       string
+		exposition exposition
+		expected   string
+	}{
+		{
+			"001",
+			exposition{Format: formatText},
+			`# HELP iperf3_bits_per_second Throughput of the run, as seen by the sender or the receiver.
+# TYPE iperf3_bits_per_second gauge
+iperf3_bits_per_second{direction="download",role="receiver"} 9e+08
+iperf3_bits_per_second{direction="download",role="sender"} 9.1e+08
+# HELP iperf3_download_sent_bits_per_second Deprecated, use iperf3_bits_per_second{direction="download",role="sender"}.
+# TYPE iperf3_download_sent_bits_per_second gauge
+iperf3_download_sent_bits_per_second 9e+08
+go_goroutines 8
+# HELP iperf3_errors Failed probes by reason.
+# TYPE iperf3_errors counter
+iperf3_errors{reason="busy"} 2
+`,
+		},
+		{
+			"002",
+			exposition{Format: formatOpenMetrics},
+			`# HELP iperf3_bits_per_second Throughput of the run, as seen by the sender or the receiver.
+# TYPE iperf3_bits_per_second gauge
+iperf3_bits_per_second{direction="download",role="receiver"} 9e+08
+iperf3_bits_per_second{direction="download",role="sender"} 9.1e+08
+# HELP iperf3_download_sent_bits_per_second Deprecated, use iperf3_bits_per_second{direction=\"download\",role=\"sender\"}.
+# TYPE iperf3_download_sent_bits_per_second gauge
+iperf3_download_sent_bits_per_second 9e+08
+go_goroutines 8
+# HELP iperf3_errors Failed probes by reason.
+# TYPE iperf3_errors unknown
+iperf3_errors{reason="busy"} 2
+# EOF
+`,
+		},
+		{
+			"003",
+			exposition{Format: formatText, Prefix: "speedtest_", Labels: labels{"site": "berlin", "uplink": "fiber"}},
+			`# HELP speedtest_bits_per_second Throughput of the run, as seen by the sender or the receiver.
+# TYPE speedtest_bits_per_second gauge
+speedtest_bits_per_second{direction="download",role="receiver",site="berlin",uplink="fiber"} 9e+08
+speedtest_bits_per_second{direction="download",role="sender",site="berlin",uplink="fiber"} 9.1e+08
+# HELP speedtest_download_sent_bits_per_second Deprecated, use speedtest_bits_per_second{direction="download",role="sender"}.
+# TYPE speedtest_download_sent_bits_per_second gauge
+speedtest_download_sent_bits_per_second{site="berlin",uplink="fiber"} 9e+08
+go_goroutines{site="berlin",uplink="fiber"} 8
+# HELP speedtest_errors Failed probes by reason.
+# TYPE speedtest_errors counter
+speedtest_errors{reason="busy",site="berlin",uplink="fiber"} 2
+`,
+		},
+	}
+
+	for _, table := range tables {
+		var b bytes.Buffer
+
+		table.exposition.write(&b, []byte(text))
+		require.Equal(t, table.expected, b.String(), table.name)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) { //nolint:lll
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		accept   string
+		expected format
+	}{
+		{"001", "", formatText},
+		{"002", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1", formatText},
+		{
+			"003",
+			"application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			formatOpenMetrics,
+		},
+		{"004", "application/openmetrics-text;q=0,text/plain", formatText},
+		{"005", "application/openmetrics-text;q=0.4,text/plain;q=0.5", formatText},
+		{"006", "text/plain;q=0.5, application/openmetrics-text; version=1.0.0", formatOpenMetrics},
+	}
+
+	for _, table := range tables {
+		r, err := http.NewRequest(http.MethodGet, "/probe", nil)
+		require.NoError(t, err)
+
+		if table.accept != "" {
+			r.Header.Set("Accept", table.accept)
+		}
+
+		require.Equal(t, table.expected, negotiateFormat(r), table.name)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		line     string
+		expected sample
+		err      error
+	}{
+		{"001", "go_goroutines 8", sample{"go_goroutines", labels{}, "8"}, nil},
+		{"002", `iperf3_errors{reason="busy"} 2`, sample{"iperf3_errors", labels{"reason": "busy"}, "2"}, nil},
+		{
+			"003",
+			`x{a="x,b=\"y\"", c="}\n"} 1 1700000000000`,
+			sample{"x", labels{"a": `x,b="y"`, "c": "}\n"}, "1 1700000000000"},
+			nil,
+		},
+		{"004", `x{a="b"`, sample{}, ErrInvalidSample},
+		{"005", "x", sample{}, ErrInvalidSample},
+	}
+
+	for _, table := range tables {
+		s, err := parseSample(table.line)
+		require.ErrorIs(t, err, table.err, table.name)
+		require.Equal(t, table.expected, s, table.name)
+	}
+}
+
+func TestWriteExpositionProcessMetrics(t *testing.T) {
+	t.Parallel()
+
+	var b, out bytes.Buffer
+
+	metrics.WritePrometheus(&b, true)
+
+	e := exposition{Format: formatOpenMetrics, Labels: labels{"site": "berlin"}}
+	e.write(&out, b.Bytes())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Equal(t, "# EOF", lines[len(lines)-1])
+	require.Contains(t, out.String(), `go_info_ext{GOARCH="`)
+
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+
+		require.Regexp(t, openMetricsSampleRe, line)
+		require.Contains(t, line, `site="berlin"`)
+	}
+}
+
+// openMetricsSampleRe matches sample lines OpenMetrics accepts, without whitespace in the
+// labels.
+var openMetricsSampleRe = regexp.MustCompile(
+	`^[a-zA-Z_:][a-zA-Z0-9_:]*\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"` +
+		`(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\} [^ ]+( [^ ]+)?$`,
+)
+
+func TestValidLabelName(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name  string
+		label string
+		valid bool
+	}{
+		{"001", "site", true},
+		{"002", "_uplink2", true},
+		{"003", "my-site", false},
+		{"004", "2site", false},
+		{"005", "__name__", false},
+		{"006", "", false},
+		{"007", "role", false},
+		{"008", "direction", false},
+	}
+
+	validate := newValidator()
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validate.Var(table.label, "label_name,unreserved_label_name")
+			if table.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/VictoriaMetrics/metrics v1.18.0
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/go-playground/validator/v10 v10.2.0
 	github.com/golangci/golangci-lint v1.43.0
 	github.com/goreleaser/goreleaser v1.0.0
@@ -12,6 +13,7 @@ require (
 	github.com/spf13/viper v1.9.0
 	github.com/stretchr/testify v1.7.0
 	go.xsfx.dev/logginghandler v0.0.4
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -101,7 +103,6 @@ require (
 	github.com/ettle/strcase v0.1.1 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/fatih/structtag v1.2.0 // indirect
-	github.com/fsnotify/fsnotify v1.5.1 // indirect
 	github.com/fzipp/gocyclo v0.3.1 // indirect
 	github.com/go-critic/go-critic v0.6.1 // indirect
 	github.com/go-git/gcfg v1.5.0 // indirect
@@ -262,7 +263,6 @@ require (
 	gopkg.in/ini.v1 v1.63.2 // indirect
 	gopkg.in/mail.v2 v2.3.1 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	honnef.co/go/tools v0.2.1 // indirect
 	mvdan.cc/gofumpt v0.1.1 // indirect
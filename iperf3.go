@@ -0,0 +1,164 @@
+//nolint:gochecknoglobals
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// iperfVersion is the version of the installed iperf3 binary.
+type iperfVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v iperfVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// atLeast reports if v is the same or a newer version than o.
+func (v iperfVersion) atLeast(o iperfVersion) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+
+	if v.Minor != o.Minor {
+		return v.Minor > o.Minor
+	}
+
+	return v.Patch >= o.Patch
+}
+
+// capabilities maps iperf3 options to the first iperf3 version that supports them.
+var capabilities = map[string]iperfVersion{
+	"--get-server-output":   {3, 1, 0},
+	"--rsa-public-key-path": {3, 5, 0},
+	"--bidir":               {3, 7, 0},
+	"--bind-dev":            {3, 15, 0},
+}
+
+var versionRe = regexp.MustCompile(`iperf (\d+)\.(\d+)(?:\.(\d+))?`)
+
+var (
+	ErrUnknownVersion    = errors.New("could not determine iperf3 version")
+	ErrUnsupportedOption = errors.New("option not supported by iperf3 binary")
+)
+
+// versionDetectTimeout is the time `iperf3 --version` is allowed to take.
+const versionDetectTimeout = 10 * time.Second
+
+// parseVersion extracts the version out of the `iperf3 --version` output.
+func parseVersion(out string) (iperfVersion, error) {
+	m := versionRe.FindStringSubmatch(out)
+	if m == nil {
+		return iperfVersion{}, ErrUnknownVersion
+	}
+
+	var (
+		v   iperfVersion
+		err error
+	)
+
+	if v.Major, err = strconv.Atoi(m[1]); err != nil {
+		return iperfVersion{}, fmt.Errorf("could not convert major version: %w", err)
+	}
+
+	if v.Minor, err = strconv.Atoi(m[2]); err != nil {
+		return iperfVersion{}, fmt.Errorf("could not convert minor version: %w", err)
+	}
+
+	if m[3] != "" {
+		if v.Patch, err = strconv.Atoi(m[3]); err != nil {
+			return iperfVersion{}, fmt.Errorf("could not convert patch version: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+// detectVersion runs the iperf3 binary with `--version` and parses its output.
+func detectVersion(path string) (iperfVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), versionDetectTimeout)
+	defer cancel()
+
+	// Some iperf3 builds exit non-zero on `--version`. Only fail if the output is not usable.
+	out, runErr := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+
+	v, err := parseVersion(string(out))
+	if err != nil {
+		if runErr != nil {
+			return iperfVersion{}, fmt.Errorf("could not run %s: %w", path, runErr)
+		}
+
+		return iperfVersion{}, err
+	}
+
+	return v, nil
+}
+
+// checkCapabilities makes sure that all modules and their steps only use options the iperf3
+// binary supports.
+func checkCapabilities(v iperfVersion, mods map[string]module) error {
+	names := make([]string, 0, len(mods))
+	for n := range mods {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		// Steps run with their own options, so they get checked as well.
+		whats := []string{fmt.Sprintf("module %q", n)}
+		ms := []module{mods[n]}
+
+		for _, st := range mods[n].Steps {
+			whats = append(whats, fmt.Sprintf("module %q step %q", n, st.Name))
+			ms = append(ms, st.module(mods[n]))
+		}
+
+		for i, m := range ms {
+			for _, a := range m.args() {
+				min, ok := capabilities[a]
+				if !ok || v.atLeast(min) {
+					continue
+				}
+
+				return fmt.Errorf("%s: %s needs iperf3 >= %s, found %s: %w", whats[i], a, min, v, ErrUnsupportedOption)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadBinary detects the version of the configured iperf3 binary, exports it and checks
+// that all configured modules can be used with it.
+func loadBinary() error {
+	v, err := detectVersion(c.Iperf3.Binary)
+	if err != nil {
+		return err
+	}
+
+	mods := make(map[string]module, len(c.Modules))
+	for n, m := range c.Modules {
+		m.Auth = m.Auth.or(c.Auth)
+		mods[n] = m
+	}
+
+	if err := checkCapabilities(v, mods); err != nil {
+		return err
+	}
+
+	metrics.NewGauge(fmt.Sprintf(`iperf3_binary_info{version=%q}`, v), func() float64 { return 1 })
+
+	return nil
+}
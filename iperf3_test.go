@@ -0,0 +1,110 @@
+package main //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		out      string
+		expected iperfVersion
+		err      error
+	}{
+		{
+			"001",
+			"iperf 3.9 (cJSON 1.7.13)\nLinux foobar 5.10.0 #1 SMP x86_64\n",
+			iperfVersion{3, 9, 0},
+			nil,
+		},
+		{
+			"002",
+			"iperf 3.17.1 (cJSON 1.7.15)\n",
+			iperfVersion{3, 17, 1},
+			nil,
+		},
+		{
+			"003",
+			"iperf 3.7-dev (cJSON 1.5.2)\n",
+			iperfVersion{3, 7, 0},
+			nil,
+		},
+		{
+			"004",
+			"command not found",
+			iperfVersion{},
+			ErrUnknownVersion,
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+			v, err := parseVersion(table.out)
+			require.ErrorIs(err, table.err)
+			require.Equal(table.expected, v)
+		})
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name    string
+		version iperfVersion
+		mods    map[string]module
+		err     error
+	}{
+		{
+			"001",
+			iperfVersion{3, 9, 0},
+			map[string]module{"default": {Time: 5}},
+			nil,
+		},
+		{
+			"002",
+			iperfVersion{3, 9, 0},
+			map[string]module{"lan": {BindDev: "eth0"}},
+			ErrUnsupportedOption,
+		},
+		{
+			"003",
+			iperfVersion{3, 16, 0},
+			map[string]module{"lan": {BindDev: "eth0"}},
+			nil,
+		},
+		{
+			"004",
+			iperfVersion{3, 6, 0},
+			map[string]module{"duplex": {Direction: "bidir"}},
+			ErrUnsupportedOption,
+		},
+		{
+			"005",
+			iperfVersion{3, 6, 0},
+			map[string]module{"profile": {Steps: []step{{Name: "duplex", Direction: "bidir"}}}},
+			ErrUnsupportedOption,
+		},
+		{
+			"006",
+			iperfVersion{3, 7, 0},
+			map[string]module{"profile": {Steps: []step{{Name: "duplex", Direction: "bidir"}}}},
+			nil,
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require.ErrorIs(t, checkCapabilities(table.version, table.mods), table.err)
+		})
+	}
+}
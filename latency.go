@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrUnreachable = errors.New("target unreachable")
+
+// connectTimeout is the time a single TCP connect to an iperf3 control port may take.
+const connectTimeout = 2 * time.Second
+
+// Defaults of the latency under load measurement.
+const (
+	idleConnects           = 5
+	defaultLatencyInterval = 250 * time.Millisecond
+)
+
+// connectTime measures the time it takes to open a TCP connection to the target.
+func connectTime(ctx context.Context, t Target) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	var d net.Dialer
+
+	start := time.Now()
+
+	conn, err := d.DialContext(ctx, "tcp", t.String())
+	if err != nil {
+		return 0, fmt.Errorf("could not connect: %w", err)
+	}
+
+	rtt := time.Since(start)
+
+	conn.Close()
+
+	return rtt, nil
+}
+
+// connectStats are the results of several TCP connects to a target.
+type connectStats struct {
+	Min      time.Duration
+	Avg      time.Duration
+	Max      time.Duration
+	Failures int
+}
+
+// newConnectStats calculates the stats of the connect times of all successful connects.
+func newConnectStats(rtts []time.Duration, failures int) connectStats {
+	s := connectStats{Failures: failures}
+
+	if len(rtts) == 0 {
+		return s
+	}
+
+	var sum time.Duration
+
+	s.Min = rtts[0]
+
+	for _, rtt := range rtts {
+		sum += rtt
+
+		if rtt < s.Min {
+			s.Min = rtt
+		}
+
+		if rtt > s.Max {
+			s.Max = rtt
+		}
+	}
+
+	s.Avg = sum / time.Duration(len(rtts))
+
+	return s
+}
+
+// measureConnects connects n times to the target, one after another.
+func measureConnects(ctx context.Context, t Target, n int) connectStats {
+	var (
+		rtts     []time.Duration
+		failures int
+	)
+
+	for i := 0; i < n; i++ {
+		rtt, err := connectTime(ctx, t)
+		if err != nil {
+			failures++
+
+			continue
+		}
+
+		rtts = append(rtts, rtt)
+	}
+
+	return newConnectStats(rtts, failures)
+}
+
+// checkConnect measures the connect time to the control port of the probe target before
+// the iperf3 runs start. If no connect succeeds, the target is unreachable.
+func checkConnect(ctx context.Context, p probe, pm *probeMetrics) error {
+	s := measureConnects(ctx, p.Target, p.Module.Connects)
+
+	pm.set("iperf3_connect_failures", float64(s.Failures))
+
+	if s.Failures == p.Module.Connects {
+		return fmt.Errorf("%w: no connect to %s succeeded", ErrUnreachable, p.Target)
+	}
+
+	pm.set("iperf3_connect_min_seconds", s.Min.Seconds())
+	pm.set("iperf3_connect_avg_seconds", s.Avg.Seconds())
+	pm.set("iperf3_connect_max_seconds", s.Max.Seconds())
+
+	return nil
+}
+
+// measureInterval connects to the target in the interval until the context is done.
+// It returns a channel that receives the stats after it stopped.
+func measureInterval(ctx context.Context, t Target, interval time.Duration) <-chan connectStats {
+	ch := make(chan connectStats, 1)
+
+	go func() {
+		var (
+			rtts     []time.Duration
+			failures int
+		)
+
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			rtt, err := connectTime(ctx, t)
+
+			switch {
+			case ctx.Err() != nil, pastDeadline(ctx):
+				// Connects that got canceled are not counted.
+			case err != nil:
+				failures++
+			default:
+				rtts = append(rtts, rtt)
+			}
+
+			select {
+			case <-ctx.Done():
+				ch <- newConnectStats(rtts, failures)
+
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+
+	return ch
+}
+
+// pastDeadline reports if the deadline of the context is over. The dialer uses the deadline
+// directly, so a connect can time out before the context reports its error.
+func pastDeadline(ctx context.Context) bool {
+	d, ok := ctx.Deadline()
+
+	return ok && !time.Now().Before(d)
+}
+
+// latencyTarget returns the target the latency under load is measured against.
+func latencyTarget(p probe) (Target, error) {
+	if p.Module.LatencyTarget == "" {
+		return p.Target, nil
+	}
+
+	t, err := NewTarget(p.Module.LatencyTarget)
+	if err != nil {
+		return Target{}, fmt.Errorf("could not determine latency target: %w", err)
+	}
+
+	return t, nil
+}
+
+// measureIdle measures the connect time to the latency target before any load is put on
+// the link.
+func measureIdle(ctx context.Context, p probe, pm *probeMetrics) (connectStats, error) {
+	t, err := latencyTarget(p)
+	if err != nil {
+		return connectStats{}, err
+	}
+
+	s := measureConnects(ctx, t, idleConnects)
+	if s.Failures == idleConnects {
+		return connectStats{}, fmt.Errorf("%w: no connect to latency target %s succeeded", ErrUnreachable, t)
+	}
+
+	pm.set("iperf3_latency_idle_seconds", s.Avg.Seconds())
+
+	return s, nil
+}
+
+// underLoad runs a phase while measuring the connect time to the latency target. It sets
+// the loaded latency and its increase over the idle latency for the direction.
+func underLoad(
+	ctx context.Context,
+	p probe,
+	pm *probeMetrics,
+	direction string,
+	idle connectStats,
+	phase func() error,
+) error {
+	t, err := latencyTarget(p)
+	if err != nil {
+		return err
+	}
+
+	interval := p.Module.LatencyInterval
+	if interval == 0 {
+		interval = defaultLatencyInterval
+	}
+
+	mctx, cancel := context.WithCancel(ctx)
+	ch := measureInterval(mctx, t, interval)
+
+	err = phase()
+
+	cancel()
+
+	s := <-ch
+	l := labels{"direction": direction}
+
+	pm.setWithLabels("iperf3_latency_loaded_failures", l, float64(s.Failures))
+
+	if s.Avg != 0 {
+		pm.setWithLabels("iperf3_latency_loaded_seconds", l, s.Avg.Seconds())
+		pm.setWithLabels("iperf3_latency_increase_seconds", l, (s.Avg - idle.Avg).Seconds())
+	}
+
+	return err
+}
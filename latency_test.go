@@ -0,0 +1,66 @@
+package main //nolint:testpackage
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConnectStats(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		rtts     []time.Duration
+		failures int
+		expected connectStats
+	}{
+		{
+			"001",
+			[]time.Duration{3 * time.Millisecond, time.Millisecond, 5 * time.Millisecond},
+			0,
+			connectStats{time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond, 0},
+		},
+		{
+			"002",
+			[]time.Duration{2 * time.Millisecond},
+			2,
+			connectStats{2 * time.Millisecond, 2 * time.Millisecond, 2 * time.Millisecond, 2},
+		},
+		{
+			"003",
+			nil,
+			3,
+			connectStats{Failures: 3},
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, table.expected, newConnectStats(table.rtts, table.failures))
+		})
+	}
+}
+
+func TestMeasureInterval(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	s := <-measureInterval(ctx, Target{"127.0.0.1", l.Addr().(*net.TCPAddr).Port}, 10*time.Millisecond)
+	require.Zero(s.Failures)
+	require.NotZero(s.Avg)
+	require.LessOrEqual(s.Min, s.Max)
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -39,7 +40,18 @@ var rootCmd = &cobra.Command{
 			return
 		}
 
+		if err := loadBinary(); err != nil {
+			log.Fatal().Err(err).Str("binary", c.Iperf3.Binary).Msg("could not load iperf3 binary")
+		}
+
+		initScrapeErrors()
+
+		if err := startDiscovery(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("could not start target discovery")
+		}
+
 		http.Handle("/probe", logginghandler.Handler(http.HandlerFunc(probeHandler)))
+		http.Handle("/sd", logginghandler.Handler(http.HandlerFunc(sdHandler)))
 		log.Info().Str("listen", c.Exporter.Listen).Msg("starting...")
 		log.Fatal().Err(http.ListenAndServe(c.Exporter.Listen, nil)).Msg("goodbye")
 	},
@@ -50,73 +62,271 @@ type config struct {
 	Exporter struct {
 		Listen         string        `validate:"required,hostname_port"`
 		Timeout        time.Duration `validate:"required,gt=0"`
+		TimeoutOffset  time.Duration `mapstructure:"timeout_offset" validate:"gte=0"`
 		ProcessMetrics bool          `mapstructure:"process_metrics" validate:"required"`
+		LegacyMetrics  bool          `mapstructure:"legacy_metrics"`
+
+		// MetricPrefix replaces the `iperf3_` prefix of the metric names.
+		MetricPrefix string `mapstructure:"metric_prefix" validate:"required,metric_prefix"`
+
+		// Labels get added to every series of the exporter.
+		Labels map[string]string `validate:"dive,keys,label_name,unreserved_label_name,endkeys"`
 	}
 	Log struct {
 		JSON   bool
 		Colors bool `validate:"required"`
 	}
 	Iperf3 struct {
-		Time int           `validate:"required"`
-		Wait time.Duration `validation:"required,min=1ms"`
+		Binary   string        `validate:"required"`
+		Time     int           `validate:"required"`
+		Wait     time.Duration `validation:"required,min=1ms"`
+		Connects int           `validate:"gte=0"`
+	}
+	Overrides overrideLimits
+	Auth      auth
+	Modules   map[string]module `validate:"dive"`
+	Targets   []targetConfig    `validate:"unique=Name,dive"`
+	Discovery struct {
+		Files  []fileSDConfig `validate:"dive"`
+		DNSSRV []srvSDConfig  `mapstructure:"dns_srv" validate:"dive"`
 	}
 }
 
 // c is a global config struct instance.
 var c config
 
+// validate validates the config and the parameters of probe requests.
+var validate = newValidator() //nolint:gochecknoglobals
+
 var (
 	cfgFile     string
 	versionFlag bool
 )
 
-var (
-	downloadSentBitsPerSecond     = metrics.NewFloatCounter("iperf3_download_sent_bits_per_second")
-	downloadSentSeconds           = metrics.NewFloatCounter("iperf3_download_sent_seconds")
-	downloadSentBytes             = metrics.NewFloatCounter("iperf3_download_sent_bytes")
-	downloadSentRetransmits       = metrics.NewFloatCounter("iperf3_download_sent_retransmits")
-	downloadReceivedBitsPerSecond = metrics.NewFloatCounter("iperf3_download_received_bits_per_second")
-	downloadReceivedSeconds       = metrics.NewFloatCounter("iperf3_download_received_seconds")
-	downloadReceivedBytes         = metrics.NewFloatCounter("iperf3_download_received_bytes")
+// Failure reasons of a probe.
+const (
+	reasonTarget      = "target"
+	reasonOverride    = "override"
+	reasonUnreachable = "unreachable"
+	reasonBusy        = "busy"
+	reasonAuth        = "auth"
+	reasonTimeout     = "timeout"
+	reasonCanceled    = "canceled"
+	reasonError       = "error"
 )
 
-var (
-	uploadSentBitsPerSecond     = metrics.NewFloatCounter("iperf3_upload_sent_bits_per_second")
-	uploadSentSeconds           = metrics.NewFloatCounter("iperf3_upload_sent_seconds")
-	uploadSentBytes             = metrics.NewFloatCounter("iperf3_upload_sent_bytes")
-	uploadSentRetransmits       = metrics.NewFloatCounter("iperf3_upload_sent_retransmits")
-	uploadReceivedBitsPerSecond = metrics.NewFloatCounter("iperf3_upload_received_bits_per_second")
-	uploadReceivedSeconds       = metrics.NewFloatCounter("iperf3_upload_received_seconds")
-	uploadReceivedBytes         = metrics.NewFloatCounter("iperf3_upload_received_bytes")
-)
+// failureReasons are all failure reasons of a probe.
+var failureReasons = []string{ //nolint:gochecknoglobals
+	reasonTarget,
+	reasonOverride,
+	reasonUnreachable,
+	reasonBusy,
+	reasonAuth,
+	reasonTimeout,
+	reasonCanceled,
+	reasonError,
+}
 
-var scrapeErrors = metrics.NewCounter("iperf3_errors")
+// scrapeErrors returns the counter of failed probes with the reason.
+func scrapeErrors(reason string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`iperf3_errors{reason=%q}`, reason))
+}
+
+// initScrapeErrors creates the counters of all failure reasons, so they are exported before
+// the first probe fails.
+func initScrapeErrors() {
+	for _, reason := range failureReasons {
+		scrapeErrors(reason)
+	}
+}
+
+// scrapeError counts a failed probe by its reason.
+func scrapeError(reason string) {
+	scrapeErrors(reason).Inc()
+}
+
+// failureReason returns the failure reason of a probe error.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnreachable):
+		return reasonUnreachable
+	case errors.Is(err, ErrServerBusy):
+		return reasonBusy
+	case errors.Is(err, ErrAuth):
+		return reasonAuth
+	case errors.Is(err, ErrNoTimeLeft), errors.Is(err, context.DeadlineExceeded):
+		return reasonTimeout
+	case errors.Is(err, context.Canceled):
+		return reasonCanceled
+	default:
+		return reasonError
+	}
+}
+
+// serverError reports if the error is about the iperf3 server itself. It is unreachable,
+// busy or refused the credentials, so further runs against it would fail the same way.
+func serverError(err error) bool {
+	return errors.Is(err, ErrUnreachable) || errors.Is(err, ErrServerBusy) || errors.Is(err, ErrAuth)
+}
+
+//nolint:tagliatelle
+type iperfSum struct {
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	Seconds       float64 `json:"seconds"`
+	Bytes         float64 `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"`
+	Sender        bool    `json:"sender"`
+	Omitted       bool    `json:"omitted"`
+
+	// Only set for UDP runs.
+	JitterMs    float64 `json:"jitter_ms"`
+	LostPackets int     `json:"lost_packets"`
+	Packets     int     `json:"packets"`
+	LostPercent float64 `json:"lost_percent"`
+}
+
+//nolint:tagliatelle
+type iperfInterval struct {
+	Sum iperfSum `json:"sum"`
+
+	// Only set for --bidir runs.
+	SumBidirReverse iperfSum `json:"sum_bidir_reverse"`
+}
 
 //nolint:tagliatelle
 type iperfResult struct {
+	Error string `json:"error"`
+
+	// Partial is set if the run got interrupted before it was done.
+	Partial bool `json:"-"`
+
+	// Capacity is set if the result is from a UDP capacity search.
+	Capacity *udpCapacity `json:"-"`
+
+	Start struct {
+		Timestamp struct {
+			Timesecs int64 `json:"timesecs"`
+		} `json:"timestamp"`
+
+		TestStart struct {
+			Protocol string `json:"protocol"`
+		} `json:"test_start"`
+	} `json:"start"`
+
+	Intervals []iperfInterval `json:"intervals"`
+
+	// ServerOutput is the result of the server. Only set for runs with --get-server-output.
+	ServerOutput *iperfResult `json:"server_output_json"`
+
 	End struct {
-		SumSent struct {
-			Seconds       float64 `json:"seconds"`
-			Bytes         float64 `json:"bytes"`
-			BitsPerSecond float64 `json:"bits_per_second"`
-			Retransmits   int     `json:"retransmits"`
-		} `json:"sum_sent"`
-		SumReceived struct {
-			Seconds       float64 `json:"seconds"`
-			Bytes         float64 `json:"bytes"`
-			BitsPerSecond float64 `json:"bits_per_second"`
-		} `json:"sum_received"`
+		SumSent     iperfSum `json:"sum_sent"`
+		SumReceived iperfSum `json:"sum_received"`
+
+		// Only set for --bidir runs.
+		SumSentBidirReverse     iperfSum `json:"sum_sent_bidir_reverse"`
+		SumReceivedBidirReverse iperfSum `json:"sum_received_bidir_reverse"`
+
+		// Only set for UDP runs.
+		Sum iperfSum `json:"sum"`
+
+		CPUUtilizationPercent struct {
+			HostTotal   float64 `json:"host_total"`
+			RemoteTotal float64 `json:"remote_total"`
+		} `json:"cpu_utilization_percent"`
+
+		SenderTCPCongestion string `json:"sender_tcp_congestion"`
 	} `json:"end"`
 }
 
+// udp reports if the result is from a UDP run.
+func (r iperfResult) udp() bool {
+	return r.Start.TestStart.Protocol == "UDP"
+}
+
+// withOmitted returns the seconds of all omitted intervals and the bits per second of all
+// intervals, including the omitted ones. iperf3 leaves the omitted intervals out of its
+// end sums.
+func (r iperfResult) withOmitted() (float64, float64) {
+	var omitted, seconds, bytes float64
+
+	for _, i := range r.Intervals {
+		if i.Sum.Omitted {
+			omitted += i.Sum.Seconds
+		}
+
+		seconds += i.Sum.Seconds
+		bytes += i.Sum.Bytes
+	}
+
+	if seconds == 0 {
+		return omitted, 0
+	}
+
+	return omitted, bytes * 8 / seconds
+}
+
+// splitBidir splits the result of a --bidir run into a download and an upload result. The
+// sender flag of the sums tells if the client was the sending side. Both results keep the
+// CPU utilization of the whole run and get their half of the server output.
+func splitBidir(r iperfResult) (iperfResult, iperfResult) {
+	var down, up iperfResult
+
+	pairs := [][2]iperfSum{
+		{r.End.SumSent, r.End.SumReceived},
+		{r.End.SumSentBidirReverse, r.End.SumReceivedBidirReverse},
+	}
+
+	for _, pair := range pairs {
+		d := iperfResult{Partial: r.Partial, Start: r.Start}
+
+		d.End.SumSent = pair[0]
+		d.End.SumReceived = pair[1]
+		d.End.SenderTCPCongestion = r.End.SenderTCPCongestion
+		d.End.CPUUtilizationPercent = r.End.CPUUtilizationPercent
+
+		for _, i := range r.Intervals {
+			for _, sum := range []iperfSum{i.Sum, i.SumBidirReverse} {
+				if sum.Sender == pair[0].Sender {
+					d.Intervals = append(d.Intervals, iperfInterval{Sum: sum})
+				}
+			}
+		}
+
+		if pair[0].Sender {
+			up = d
+		} else {
+			down = d
+		}
+	}
+
+	// The server sends the download, so the half of the server output it sent in belongs to
+	// the download.
+	if r.ServerOutput != nil {
+		received, sent := splitBidir(*r.ServerOutput)
+		down.ServerOutput = &sent
+		up.ServerOutput = &received
+	}
+
+	return down, up
+}
+
 type Target struct {
 	Host string
 	Port int
 }
 
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
 var (
 	ErrEmptyTarget             = errors.New("empty target")
 	ErrCouldNotDetermineTarget = errors.New("could not determine target")
+	ErrIperf3                  = errors.New("iperf3 error")
+	ErrServerBusy              = errors.New("server is busy")
+	ErrNoTimeLeft              = errors.New("no time left for the run")
 )
 
 func NewTarget(t string) (Target, error) {
@@ -155,89 +365,255 @@ func NewTarget(t string) (Target, error) {
 	return trg, nil
 }
 
-func runIperf(ctx context.Context, t Target, cmdArgs []string, logger zerolog.Logger) (iperfResult, error) {
+// interruptLead is the time before the deadline iperf3 gets interrupted. It needs that time
+// to print the results of an interrupted run.
+const interruptLead = time.Second
+
+// minRunTime is the shortest time before the deadline an iperf3 run still gets started.
+// Shorter runs would get interrupted before they had a chance to connect.
+const minRunTime = 2 * interruptLead
+
+// waitIperf waits for the iperf3 command to exit. Shortly before the deadline of the context
+// it gets interrupted, so it prints the results it has so far. It gets killed once the
+// context is done.
+func waitIperf(ctx context.Context, cmd *exec.Cmd) (bool, error) {
+	done := make(chan error, 1)
+
+	go func() { done <- cmd.Wait() }()
+
+	var interrupt <-chan time.Time
+
+	if d, ok := ctx.Deadline(); ok {
+		t := time.NewTimer(time.Until(d) - interruptLead)
+		defer t.Stop()
+
+		interrupt = t.C
+	}
+
+	interrupted := false
+
+	for {
+		select {
+		case err := <-done:
+			return interrupted, err
+		case <-interrupt:
+			interrupted = true
+			interrupt = nil
+
+			if err := cmd.Process.Signal(os.Interrupt); err != nil {
+				return interrupted, fmt.Errorf("could not interrupt command: %w", err)
+			}
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+			<-done
+
+			return interrupted, fmt.Errorf("command killed: %w", ctx.Err())
+		}
+	}
+}
+
+// parseResult parses the JSON output of an iperf3 run. runErr is the error the command exited
+// with. The results of an interrupted run are marked as partial.
+func parseResult(out []byte, runErr error, interrupted bool) (iperfResult, error) {
+	var p iperfResult
+
+	jsonErr := json.Unmarshal(out, &p)
+
+	switch {
+	case jsonErr == nil && interrupted && p.End.SumSent.Seconds > 0:
+		p.Partial = true
+
+		return p, nil
+	case runErr == nil && jsonErr != nil:
+		return iperfResult{}, fmt.Errorf("could not unmarshal result: %w", jsonErr)
+	case runErr == nil:
+		return p, nil
+	case jsonErr == nil && p.Error != "":
+		// iperf3 reports its own errors in the JSON output.
+		if strings.Contains(p.Error, "server is busy") {
+			return iperfResult{}, fmt.Errorf("%w: %s", ErrServerBusy, p.Error)
+		}
+
+		if strings.Contains(p.Error, "authorization failed") || strings.Contains(p.Error, "public key") {
+			return iperfResult{}, fmt.Errorf("%w: %s", ErrAuth, p.Error)
+		}
+
+		return iperfResult{}, fmt.Errorf("%w: %s", ErrIperf3, p.Error)
+	default:
+		return iperfResult{}, fmt.Errorf("could not run command: %w", runErr)
+	}
+}
+
+func runIperf(ctx context.Context, t Target, m module, cmdArgs []string, logger zerolog.Logger) (iperfResult, error) {
 	args := []string{
 		"-J",
 		"-c",
 		t.Host,
 		"-p",
 		fmt.Sprintf("%d", t.Port),
-		"-t", strconv.Itoa(c.Iperf3.Time),
 	}
 
+	args = append(args, m.args()...)
 	args = append(args, cmdArgs...)
 
-	cmd := exec.CommandContext(ctx, "iperf3", args...)
+	if d, ok := ctx.Deadline(); ok && time.Until(d) < minRunTime {
+		return iperfResult{}, fmt.Errorf("%w: %s left", ErrNoTimeLeft, time.Until(d).Round(time.Millisecond))
+	}
+
+	username, password, err := m.Auth.credentials()
+	if err != nil {
+		return iperfResult{}, err
+	}
 
-	logger.Debug().Str("cmd", cmd.String()).Msg("created command")
+	if username != "" {
+		args = append(args, "--username", username)
+	}
+
+	cmd := exec.Command(c.Iperf3.Binary, args...)
+
+	// The password is only passed through the environment of iperf3. It never shows up on
+	// the command line or in the logs.
+	if password != "" {
+		cmd.Env = append(os.Environ(), "IPERF3_PASSWORD="+password)
+	}
+
+	logger.Debug().Str("cmd", redactCommand(cmd)).Msg("created command")
 
 	// Buffers to store stdout and stderr.
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
+		return iperfResult{}, fmt.Errorf("could not run command: %w", err)
+	}
+
+	interrupted, runErr := waitIperf(ctx, cmd)
+	if runErr != nil {
 		logger.Debug().
 			Str("stdout", outb.String()).
 			Str("stderr", errb.String()).
+			Bool("interrupted", interrupted).
 			Msg("output from failed run")
 
-		return iperfResult{}, fmt.Errorf("could not run command: %w", err)
+		// The command got killed because the context is done.
+		if ctx.Err() != nil {
+			return iperfResult{}, fmt.Errorf("could not run command: %w", ctx.Err())
+		}
 	}
 
-	// Unmarshal the output to the iperf struct.
-	var p iperfResult
-	if err := json.Unmarshal(outb.Bytes(), &p); err != nil {
-		return iperfResult{}, fmt.Errorf("could not unmarshal result: %w", err)
+	p, err := parseResult(outb.Bytes(), runErr, interrupted)
+	if err != nil {
+		return iperfResult{}, err
+	}
+
+	if p.Partial {
+		logger.Warn().Float64("seconds", p.End.SumSent.Seconds).Msg("got partial result of interrupted run")
 	}
 
 	return p, nil
 }
 
-func download(ctx context.Context, t Target, logger zerolog.Logger) error {
+// results are iperf3 results by direction.
+type results map[string]iperfResult
+
+func download(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
 	r, err := runIperf(
 		ctx,
-		t,
+		p.Target,
+		p.Module,
 		[]string{"-R"},
 		logger,
 	)
 	if err != nil {
-		return fmt.Errorf("could not get download metrics: %w", err)
+		return nil, fmt.Errorf("could not get download metrics: %w", err)
 	}
 
-	downloadSentBitsPerSecond.Set(r.End.SumSent.BitsPerSecond)
-	downloadSentBytes.Set(r.End.SumSent.Bytes)
-	downloadSentSeconds.Set(r.End.SumSent.Seconds)
-	downloadSentRetransmits.Set(float64(r.End.SumSent.Retransmits))
+	return results{"download": r}, nil
+}
 
-	downloadReceivedBitsPerSecond.Set(r.End.SumReceived.BitsPerSecond)
-	downloadReceivedBytes.Set(r.End.SumReceived.Bytes)
-	downloadReceivedSeconds.Set(r.End.SumReceived.Seconds)
+func upload(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
+	r, err := runIperf(
+		ctx,
+		p.Target,
+		p.Module,
+		[]string{},
+		logger,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get upload metrics: %w", err)
+	}
 
-	return nil
+	return results{"upload": r}, nil
 }
 
-func upload(ctx context.Context, t Target, logger zerolog.Logger) error {
+func bidir(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
 	r, err := runIperf(
 		ctx,
-		t,
+		p.Target,
+		p.Module,
 		[]string{},
 		logger,
 	)
 	if err != nil {
-		return fmt.Errorf("could not get upload metrics: %w", err)
+		return nil, fmt.Errorf("could not get bidir metrics: %w", err)
+	}
+
+	down, up := splitBidir(r)
+
+	return results{"download": down, "upload": up}, nil
+}
+
+// runProbe runs the probe and returns its metrics. Pool probes try their servers until
+// one of them succeeds.
+func runProbe(ctx context.Context, p probe, logger zerolog.Logger) (*probeMetrics, error) {
+	// Counted once per probe, so the order stays the same if a pool server fails over.
+	p.Number = alternation.next(p)
+
+	if p.Pool != nil {
+		return runPool(ctx, p, logger)
+	}
+
+	pm := newProbeMetrics(p.Labels)
+
+	return pm, runPhases(ctx, p, pm, logger)
+}
+
+// minProbeTimeout is the shortest timeout a probe gets, if the scrape timeout of prometheus
+// minus the offset is shorter.
+const minProbeTimeout = time.Second
+
+// probeTimeout returns the timeout of a probe. If prometheus sends its scrape timeout, the
+// probe gets stopped the configured offset before it and never runs longer than the
+// exporter timeout. It never gets shorter than minProbeTimeout.
+func probeTimeout(r *http.Request) time.Duration {
+	timeout := c.Exporter.Timeout
+
+	h := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if h == "" {
+		return timeout
+	}
+
+	secs, err := strconv.ParseFloat(h, 64)
+	if err != nil {
+		return timeout
 	}
 
-	uploadSentBitsPerSecond.Set(r.End.SumSent.BitsPerSecond)
-	uploadSentBytes.Set(r.End.SumSent.Bytes)
-	uploadSentSeconds.Set(r.End.SumSent.Seconds)
-	uploadSentRetransmits.Set(float64(r.End.SumSent.Retransmits))
+	t := time.Duration(secs*float64(time.Second)) - c.Exporter.TimeoutOffset
 
-	uploadReceivedBitsPerSecond.Set(r.End.SumReceived.BitsPerSecond)
-	uploadReceivedBytes.Set(r.End.SumReceived.Bytes)
-	uploadReceivedSeconds.Set(r.End.SumReceived.Seconds)
+	switch {
+	case t >= timeout:
+		return timeout
+	case t < minProbeTimeout:
+		// Short scrape timeouts get the shortest probe, not the longest.
+		if minProbeTimeout < timeout {
+			return minProbeTimeout
+		}
 
-	return nil
+		return timeout
+	default:
+		return t
+	}
 }
 
 func probeHandler(w http.ResponseWriter, r *http.Request) {
@@ -255,35 +631,44 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug().Str("trgt", trgt).Msg("extracted target from url params")
 
-	// Extract port and host for target.
-	t, err := NewTarget(trgt)
+	// Resolve target and module.
+	p, err := resolveProbe(trgt, r.URL.Query().Get("module"))
 	if err != nil {
-		scrapeErrors.Inc()
+		scrapeError(reasonTarget)
 		logger.Error().Err(err).Msg("could not determine target")
-		http.Error(w, "could not determine target", http.StatusUnprocessableEntity)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), c.Exporter.Timeout)
-	defer cancel()
+		http.Error(w, fmt.Sprintf("could not determine target: %s", err), http.StatusUnprocessableEntity)
 
-	logger.Info().Msg("getting download metrics")
+		return
+	}
 
-	if err := download(ctx, t, logger); err != nil {
-		scrapeErrors.Inc()
-		logger.Error().Err(err).Msg("could not create download metrics")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Apply the allowed parameter overrides.
+	p.Module, err = applyOverrides(p.Module, r.URL.Query(), c.Overrides)
+	if err != nil {
+		scrapeError(reasonOverride)
+		logger.Error().Err(err).Msg("could not apply overrides")
+		http.Error(w, fmt.Sprintf("could not apply overrides: %s", err), http.StatusUnprocessableEntity)
 
 		return
 	}
 
-	logger.Debug().Dur("wait", c.Iperf3.Wait).Msg("waiting")
-	time.Sleep(c.Iperf3.Wait)
+	// The probe stops if prometheus gives up on the scrape.
+	timeout := probeTimeout(r)
+	logger.Debug().Dur("timeout", timeout).Msg("determined timeout")
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if m, shortened := fitTime(p.Module, timeout); shortened {
+		logger.Warn().Int("time", p.Module.Time).Int("shortened", m.Time).Msg("shortened test duration to fit timeout")
 
-	logger.Info().Msg("getting upload metrics")
+		p.Module = m
+	}
 
-	if err := upload(ctx, t, logger); err != nil {
-		scrapeErrors.Inc()
-		logger.Error().Err(err).Msg("could not create upload metrics")
+	pm, err := runProbe(ctx, p, logger)
+	if err != nil {
+		reason := failureReason(err)
+		scrapeError(reason)
+		logger.Error().Err(err).Str("reason", reason).Msg("could not run probe")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
 		return
@@ -291,7 +676,19 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info().Msg("done scraping")
 
-	metrics.WritePrometheus(w, c.Exporter.ProcessMetrics)
+	var b bytes.Buffer
+
+	e := exposition{
+		Format: negotiateFormat(r),
+		Prefix: c.Exporter.MetricPrefix,
+		Labels: c.Exporter.Labels,
+	}
+
+	pm.write(&b, e.Format)
+	metrics.WritePrometheus(&b, c.Exporter.ProcessMetrics)
+
+	w.Header().Set("Content-Type", e.Format.contentType())
+	e.write(w, b.Bytes())
 }
 
 func init() { //nolint:gochecknoinits,funlen
@@ -321,6 +718,19 @@ func init() { //nolint:gochecknoinits,funlen
 
 	viper.SetDefault("exporter.timeout", time.Minute)
 
+	// Exporter.TimeoutOffset.
+	rootCmd.PersistentFlags().Duration(
+		"timeout-offset",
+		500*time.Millisecond, //nolint:gomnd
+		"offset to subtract from the prometheus scrape timeout",
+	)
+
+	if err := viper.BindPFlag("exporter.timeout_offset", rootCmd.PersistentFlags().Lookup("timeout-offset")); err != nil {
+		log.Fatal().Err(err).Msg("could not bind flag")
+	}
+
+	viper.SetDefault("exporter.timeout_offset", 500*time.Millisecond) //nolint:gomnd
+
 	// Exporter.ProcessMetrics.
 	rootCmd.PersistentFlags().Bool("process-metrics", true, "exporter process metrics")
 
@@ -330,6 +740,18 @@ func init() { //nolint:gochecknoinits,funlen
 
 	viper.SetDefault("exporter.process_metrics", true)
 
+	// Exporter.LegacyMetrics.
+	rootCmd.PersistentFlags().Bool("legacy-metrics", true, "also export the legacy per direction metric names")
+
+	if err := viper.BindPFlag("exporter.legacy_metrics", rootCmd.PersistentFlags().Lookup("legacy-metrics")); err != nil {
+		log.Fatal().Err(err).Msg("could not bind flag")
+	}
+
+	viper.SetDefault("exporter.legacy_metrics", true)
+
+	// Exporter.MetricPrefix.
+	viper.SetDefault("exporter.metric_prefix", metricPrefix)
+
 	// Log.JSON.
 	rootCmd.PersistentFlags().Bool("log-json", false, "JSON log output")
 
@@ -348,6 +770,21 @@ func init() { //nolint:gochecknoinits,funlen
 
 	viper.SetDefault("log.colors", true)
 
+	// Iperf3.Binary.
+	rootCmd.PersistentFlags().String("binary", "iperf3", "path to the iperf3 binary")
+
+	if err := viper.BindPFlag("iperf3.binary", rootCmd.PersistentFlags().Lookup("binary")); err != nil {
+		log.Fatal().Err(err).Msg("could not bind flag")
+	}
+
+	viper.SetDefault("iperf3.binary", "iperf3")
+
+	// Defaults of the credentials, so that they can be set through environment variables
+	// like IPERF3EXPORTER_AUTH_PASSWORD_FILE.
+	for _, k := range []string{"username", "username_file", "password_file", "rsa_public_key_path"} {
+		viper.SetDefault("auth."+k, "")
+	}
+
 	// Iperf3.Time.
 	rootCmd.PersistentFlags().Int("time", 5, "time in seconds to transmit for") //nolint:gomnd
 
@@ -357,6 +794,15 @@ func init() { //nolint:gochecknoinits,funlen
 
 	viper.SetDefault("iperf3.time", 5) //nolint:gomnd
 
+	// Iperf3.Connects.
+	rootCmd.PersistentFlags().Int("connects", 0, "TCP connects to the iperf3 server before each probe")
+
+	if err := viper.BindPFlag("iperf3.connects", rootCmd.PersistentFlags().Lookup("connects")); err != nil {
+		log.Fatal().Err(err).Msg("could not bind flag")
+	}
+
+	viper.SetDefault("iperf3.connects", 0)
+
 	// IPerf3.Wait.
 	rootCmd.PersistentFlags().Duration("wait", time.Second, "time to wait between download and upload runs")
 
@@ -400,7 +846,6 @@ func initConfig() {
 	log.Logger = log.With().Caller().Logger()
 
 	// Valite config.
-	validate := validator.New()
 	if err := validate.Struct(c); err != nil {
 		var validationErrors validator.ValidationErrors
 
@@ -419,6 +864,29 @@ func initConfig() {
 	}
 }
 
+// newValidator returns a validator that knows the custom tags of the exporter.
+func newValidator() *validator.Validate {
+	validate := validator.New()
+
+	if err := validate.RegisterValidation("iperf3_size", validSize); err != nil {
+		log.Fatal().Err(err).Msg("could not register validation")
+	}
+
+	if err := validate.RegisterValidation("metric_prefix", validMetricPrefix); err != nil {
+		log.Fatal().Err(err).Msg("could not register validation")
+	}
+
+	if err := validate.RegisterValidation("label_name", validLabelNameTag); err != nil {
+		log.Fatal().Err(err).Msg("could not register validation")
+	}
+
+	if err := validate.RegisterValidation("unreserved_label_name", unreservedLabelNameTag); err != nil {
+		log.Fatal().Err(err).Msg("could not register validation")
+	}
+
+	return validate
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal().Err(err).Msg("goodbye")
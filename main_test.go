@@ -1,8 +1,15 @@
 package main //nolint:testpackage
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 )
 
@@ -46,3 +53,192 @@ func TestNewTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitBidir(t *testing.T) { //nolint:lll
+	require := require.New(t)
+	t.Parallel()
+
+	out := `{
+		"end": {
+			"sum_sent": {"seconds": 5, "bytes": 25000000, "bits_per_second": 40000000, "retransmits": 2, "sender": true},
+			"sum_received": {"seconds": 5, "bytes": 24000000, "bits_per_second": 38400000, "sender": true},
+			"sum_sent_bidir_reverse": {"seconds": 5, "bytes": 500000000, "bits_per_second": 800000000, "retransmits": 7, "sender": false},
+			"sum_received_bidir_reverse": {"seconds": 5, "bytes": 490000000, "bits_per_second": 784000000, "sender": false},
+			"cpu_utilization_percent": {"host_total": 12.5}
+		},
+		"intervals": [
+			{
+				"sum": {"seconds": 1, "bytes": 1000000, "sender": true, "omitted": true},
+				"sum_bidir_reverse": {"seconds": 1, "bytes": 20000000, "sender": false, "omitted": true}
+			}
+		],
+		"server_output_json": {
+			"end": {
+				"sum_sent": {"bits_per_second": 790000000, "sender": true},
+				"sum_received": {"bits_per_second": 780000000, "sender": true},
+				"sum_sent_bidir_reverse": {"bits_per_second": 39000000, "sender": false},
+				"sum_received_bidir_reverse": {"bits_per_second": 38000000, "sender": false},
+				"cpu_utilization_percent": {"host_total": 3.5}
+			}
+		}
+	}`
+
+	var r iperfResult
+	require.NoError(json.Unmarshal([]byte(out), &r))
+
+	down, up := splitBidir(r)
+	require.Equal(800000000.0, down.End.SumSent.BitsPerSecond)
+	require.Equal(784000000.0, down.End.SumReceived.BitsPerSecond)
+	require.Equal(7, down.End.SumSent.Retransmits)
+	require.Equal(40000000.0, up.End.SumSent.BitsPerSecond)
+	require.Equal(38400000.0, up.End.SumReceived.BitsPerSecond)
+	require.Equal(2, up.End.SumSent.Retransmits)
+	require.Equal([]iperfInterval{{Sum: r.Intervals[0].SumBidirReverse}}, down.Intervals)
+	require.Equal([]iperfInterval{{Sum: r.Intervals[0].Sum}}, up.Intervals)
+	require.Equal(12.5, down.End.CPUUtilizationPercent.HostTotal)
+	require.Equal(12.5, up.End.CPUUtilizationPercent.HostTotal)
+	require.Equal(790000000.0, down.ServerOutput.End.SumSent.BitsPerSecond)
+	require.Equal(38000000.0, up.ServerOutput.End.SumReceived.BitsPerSecond)
+	require.Equal(3.5, up.ServerOutput.End.CPUUtilizationPercent.HostTotal)
+}
+
+func TestWithOmitted(t *testing.T) {
+	require := require.New(t)
+	t.Parallel()
+
+	out := `{
+		"intervals": [
+			{"sum": {"seconds": 1, "bytes": 1000000, "omitted": true}},
+			{"sum": {"seconds": 1, "bytes": 3000000, "omitted": true}},
+			{"sum": {"seconds": 1, "bytes": 5000000, "omitted": false}},
+			{"sum": {"seconds": 1, "bytes": 5000000, "omitted": false}}
+		],
+		"end": {
+			"sum_sent": {"seconds": 2, "bytes": 10000000, "bits_per_second": 40000000, "sender": true}
+		}
+	}`
+
+	var r iperfResult
+	require.NoError(json.Unmarshal([]byte(out), &r))
+
+	omitted, bps := r.withOmitted()
+	require.Equal(2.0, omitted)
+	require.Equal(28000000.0, bps)
+
+	omitted, bps = iperfResult{}.withOmitted()
+	require.Equal(0.0, omitted)
+	require.Equal(0.0, bps)
+}
+
+func TestProbeTimeout(t *testing.T) { //nolint:paralleltest
+	c.Exporter.Timeout = time.Minute
+	c.Exporter.TimeoutOffset = 500 * time.Millisecond
+
+	tables := []struct {
+		name     string
+		header   string
+		expected time.Duration
+	}{
+		{"001", "", time.Minute},
+		{"002", "10", 9500 * time.Millisecond},
+		{"003", "120", time.Minute},
+		{"004", "foo", time.Minute},
+		{"005", "0.4", time.Second},
+		{"006", "1.2", time.Second},
+	}
+
+	for _, table := range tables {
+		r := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if table.header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", table.header)
+		}
+
+		require.Equal(t, table.expected, probeTimeout(r), table.name)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	t.Parallel()
+
+	exitErr := errors.New("exit status 1")
+
+	tables := []struct {
+		name        string
+		out         string
+		runErr      error
+		interrupted bool
+		partial     bool
+		err         error
+	}{
+		{
+			"001",
+			`{"end": {"sum_sent": {"seconds": 5, "bits_per_second": 1000}}}`,
+			nil,
+			false,
+			false,
+			nil,
+		},
+		{
+			"002",
+			`{"error": "interrupt - the client has terminated", "end": {"sum_sent": {"seconds": 2.5, "bits_per_second": 1000}}}`,
+			exitErr,
+			true,
+			true,
+			nil,
+		},
+		{
+			"003",
+			`{"error": "error - the server is busy running a test. try again later"}`,
+			exitErr,
+			false,
+			false,
+			ErrServerBusy,
+		},
+		{
+			"004",
+			`{"error": "interrupt - the client has terminated"}`,
+			exitErr,
+			true,
+			false,
+			ErrIperf3,
+		},
+		{
+			"005",
+			``,
+			exitErr,
+			false,
+			false,
+			exitErr,
+		},
+		{
+			"006",
+			`{"error": "error - test authorization failed"}`,
+			exitErr,
+			false,
+			false,
+			ErrAuth,
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+			r, err := parseResult([]byte(table.out), table.runErr, table.interrupted)
+			require.ErrorIs(err, table.err)
+			require.Equal(table.partial, r.Partial)
+		})
+	}
+}
+
+func TestRunIperfNoTimeLeft(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), minRunTime-time.Millisecond)
+	defer cancel()
+
+	_, err := runIperf(ctx, Target{"iperf.tld", 5201}, module{}, nil, zerolog.Nop())
+	require.ErrorIs(t, err, ErrNoTimeLeft)
+	require.Equal(t, reasonTimeout, failureReason(err))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// module is a named set of iperf3 options. It gets selected through the `module` url parameter.
+// Unset options fall back to the values of the iperf3 config section.
+type module struct {
+	Time     int `validate:"gte=0"`
+	Wait     time.Duration
+	Parallel int    `validate:"gte=0"`
+	BindDev  string `mapstructure:"bind_dev"`
+	Connects int    `validate:"gte=0"`
+
+	// Bytes or Blocks transfer a fixed amount of data instead of running for Time. Bytes
+	// takes precedence.
+	Bytes  string `validate:"omitempty,iperf3_size"`
+	Blocks string `validate:"omitempty,iperf3_size"`
+
+	// UDP runs UDP instead of TCP tests. Bitrate limits the bitrate of every stream.
+	UDP     bool
+	Bitrate string `validate:"omitempty,iperf3_size"`
+
+	// Steps of a pipeline. If set, the probe runs the steps in their order instead of
+	// download and upload phases.
+	Steps []step `validate:"unique=Name,dive"`
+
+	// Congestion control algorithms. With more than one, the probe compares them by running
+	// its phases once per algorithm.
+	Congestion []string `validate:"unique,dive,required"`
+
+	// TOS sets the type of service of the test packets. DSCP classes are an easier way to
+	// set it. With more than one DSCP class, the phases run once per class.
+	TOS  int      `validate:"gte=0,lte=255"`
+	DSCP []string `validate:"unique,dive,oneof=BE CS0 CS1 AF11 AF12 AF13 CS2 AF21 AF22 AF23 CS3 AF31 AF32 AF33 CS4 AF41 AF42 AF43 CS5 EF CS6 CS7"` //nolint:lll
+
+	// MSS sets the TCP maximum segment size.
+	MSS int `validate:"gte=0"`
+
+	// Auth are the credentials of the iperf3 servers. Unset credentials fall back to the auth
+	// config section.
+	Auth auth
+
+	// ServerOutput gets the results of the server as well.
+	ServerOutput bool `mapstructure:"server_output"`
+
+	// Omit the first seconds of every run, to leave TCP slow start out of the results.
+	Omit int `validate:"gte=0"`
+
+	// Intervals exports the throughput of every interval of the runs as timestamped samples.
+	Intervals bool
+
+	// Direction is either both (default), which runs a download and an upload test one
+	// after another, or bidir, which tests both directions at once.
+	Direction string `validate:"omitempty,oneof=both bidir"`
+
+	// ReverseOnly only runs the download phases.
+	ReverseOnly bool `mapstructure:"reverse_only"`
+
+	// Order of the download and upload phases. alternate switches it with every probe.
+	Order           string        `validate:"omitempty,oneof=download-first upload-first alternate"`
+	DownloadTimeout time.Duration `mapstructure:"download_timeout" validate:"gte=0"`
+	UploadTimeout   time.Duration `mapstructure:"upload_timeout" validate:"gte=0"`
+
+	// Latency under load.
+	LatencyUnderLoad bool          `mapstructure:"latency_under_load"`
+	LatencyTarget    string        `mapstructure:"latency_target"`
+	LatencyInterval  time.Duration `mapstructure:"latency_interval" validate:"gte=0"`
+
+	// UDPCapacity searches the highest UDP bitrate between CapacityMin and CapacityMax
+	// with a loss of at most MaxLoss percent, instead of running TCP tests.
+	UDPCapacity   bool    `mapstructure:"udp_capacity"`
+	CapacityMin   float64 `mapstructure:"capacity_min" validate:"gte=0"`
+	CapacityMax   float64 `mapstructure:"capacity_max" validate:"omitempty,gtefield=CapacityMin"`
+	CapacitySteps int     `mapstructure:"capacity_steps" validate:"gte=0"`
+	MaxLoss       float64 `mapstructure:"max_loss" validate:"gte=0,lte=100"`
+
+	// Repetitions of every phase. Warmup adds a discarded run before them.
+	Repetitions int `validate:"gte=0"`
+	Warmup      bool
+}
+
+// directionBidir is the module direction that tests both directions at once.
+const directionBidir = "bidir"
+
+// sizeRe matches the sizes iperf3 accepts, like 100M.
+var sizeRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmMgGtT]?$`)
+
+// validSize is the validator of the iperf3_size tag.
+func validSize(fl validator.FieldLevel) bool {
+	return sizeRe.MatchString(fl.Field().String())
+}
+
+// dscpClasses are the code points of the DSCP class names.
+var dscpClasses = map[string]int{ //nolint:gochecknoglobals
+	"BE":   0,
+	"CS0":  0,
+	"CS1":  8,
+	"AF11": 10,
+	"AF12": 12,
+	"AF13": 14,
+	"CS2":  16,
+	"AF21": 18,
+	"AF22": 20,
+	"AF23": 22,
+	"CS3":  24,
+	"AF31": 26,
+	"AF32": 28,
+	"AF33": 30,
+	"CS4":  32,
+	"AF41": 34,
+	"AF42": 36,
+	"AF43": 38,
+	"CS5":  40,
+	"EF":   46,
+	"CS6":  48,
+	"CS7":  56,
+}
+
+// bidir reports if the module tests both directions at once.
+func (m module) bidir() bool {
+	return m.Direction == directionBidir && !m.UDPCapacity && !m.ReverseOnly
+}
+
+// countBased reports if the runs of the module transfer a fixed amount of data instead of
+// running for a fixed time.
+func (m module) countBased() bool {
+	return m.Bytes != "" || m.Blocks != ""
+}
+
+// tos returns the type of service of the module. A single DSCP class takes precedence
+// over the TOS option.
+func (m module) tos() int {
+	if len(m.DSCP) == 1 {
+		// The DSCP code point is the upper six bits of the TOS byte.
+		return dscpClasses[m.DSCP[0]] << 2 //nolint:gomnd
+	}
+
+	return m.TOS
+}
+
+var ErrUnknownModule = errors.New("unknown module")
+
+// getModule returns the module for name with all defaults applied. An empty name returns
+// the default module that is built from the iperf3 config section.
+func getModule(name string) (module, error) {
+	var m module
+
+	if name != "" {
+		var ok bool
+
+		m, ok = c.Modules[name]
+		if !ok {
+			return module{}, ErrUnknownModule
+		}
+	}
+
+	if m.Time == 0 {
+		m.Time = c.Iperf3.Time
+	}
+
+	if m.Wait == 0 {
+		m.Wait = c.Iperf3.Wait
+	}
+
+	if m.Connects == 0 {
+		m.Connects = c.Iperf3.Connects
+	}
+
+	m.Auth = m.Auth.or(c.Auth)
+
+	return m, nil
+}
+
+// args returns the iperf3 command line arguments for the module.
+func (m module) args() []string {
+	var args []string
+
+	switch {
+	case m.Bytes != "":
+		args = append(args, "-n", m.Bytes)
+	case m.Blocks != "":
+		args = append(args, "-k", m.Blocks)
+	case m.Time != 0:
+		args = append(args, "-t", strconv.Itoa(m.Time))
+	}
+
+	if m.Parallel != 0 {
+		args = append(args, "-P", strconv.Itoa(m.Parallel))
+	}
+
+	if m.UDP {
+		args = append(args, "-u")
+	}
+
+	if m.Bitrate != "" {
+		args = append(args, "-b", m.Bitrate)
+	}
+
+	if len(m.Congestion) == 1 {
+		args = append(args, "-C", m.Congestion[0])
+	}
+
+	if tos := m.tos(); tos != 0 {
+		args = append(args, "-S", strconv.Itoa(tos))
+	}
+
+	if m.MSS != 0 {
+		args = append(args, "-M", strconv.Itoa(m.MSS))
+	}
+
+	if m.Omit != 0 {
+		args = append(args, "-O", strconv.Itoa(m.Omit))
+	}
+
+	// iperf3 rejects a public key without a username.
+	if m.Auth.set() && m.Auth.PublicKey != "" {
+		args = append(args, "--rsa-public-key-path", m.Auth.PublicKey)
+	}
+
+	if m.ServerOutput {
+		args = append(args, "--get-server-output")
+	}
+
+	if m.bidir() {
+		args = append(args, "--bidir")
+	}
+
+	if m.BindDev != "" {
+		args = append(args, "--bind-dev", m.BindDev)
+	}
+
+	return args
+}
@@ -0,0 +1,74 @@
+package main //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleArgs(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		m        module
+		expected []string
+	}{
+		{"001", module{Time: 5}, []string{"-t", "5"}},
+		{"002", module{TOS: 184, MSS: 1400}, []string{"-S", "184", "-M", "1400"}},
+		{"003", module{TOS: 184, DSCP: []string{"AF41"}}, []string{"-S", "136"}},
+		{"004", module{DSCP: []string{"AF41", "EF"}}, nil},
+		{"005", module{DSCP: []string{"BE"}}, nil},
+		{"006", module{Congestion: []string{"bbr"}, Omit: 2}, []string{"-C", "bbr", "-O", "2"}},
+		{"007", module{Time: 5, Bytes: "100M"}, []string{"-n", "100M"}},
+		{"008", module{Time: 5, Blocks: "2000"}, []string{"-k", "2000"}},
+		{"009", module{Time: 5, Bytes: "1G", Blocks: "2000"}, []string{"-n", "1G"}},
+		{"010", module{Auth: auth{PublicKey: "/etc/public.pem"}}, nil},
+		{
+			"011",
+			module{Auth: auth{Username: "lab", PublicKey: "/etc/public.pem"}},
+			[]string{"--rsa-public-key-path", "/etc/public.pem"},
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, table.expected, table.m.args())
+		})
+	}
+}
+
+func TestValidSize(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name  string
+		size  string
+		valid bool
+	}{
+		{"001", "100M", true},
+		{"002", "1.5G", true},
+		{"003", "4096", true},
+		{"004", "10MB", false},
+		{"005", "-1", false},
+		{"006", "", false},
+	}
+
+	validate := newValidator()
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validate.Var(table.size, "iperf3_size")
+			if table.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
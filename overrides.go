@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Flags that can be allowed as overrides.
+const (
+	flagUDP         = "udp"
+	flagReverseOnly = "reverse_only"
+)
+
+var (
+	ErrOverride   = errors.New("invalid override")
+	ErrNotAllowed = errors.New("override not allowed")
+)
+
+// overrideLimits are the limits of the parameters a probe request can override. Parameters
+// without a limit can not be overridden.
+type overrideLimits struct {
+	MaxTime     int      `mapstructure:"max_time" validate:"gte=0"`
+	MaxParallel int      `mapstructure:"max_parallel" validate:"gte=0"`
+	MaxBitrate  string   `mapstructure:"max_bitrate" validate:"omitempty,iperf3_size"`
+	Flags       []string `validate:"unique,dive,oneof=udp reverse_only"`
+}
+
+// allows reports if the flag can be overridden.
+func (l overrideLimits) allows(flag string) bool {
+	for _, f := range l.Flags {
+		if f == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sizeUnits are the multipliers of the size suffixes. iperf3 uses powers of 1000 for
+// bitrates.
+var sizeUnits = map[string]float64{ //nolint:gochecknoglobals
+	"":  1,
+	"k": 1e3,
+	"m": 1e6,
+	"g": 1e9,
+	"t": 1e12,
+}
+
+// parseBitrate parses an iperf3 bitrate like 100M into bits per second.
+func parseBitrate(s string) (float64, error) {
+	if !sizeRe.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q is no bitrate", ErrOverride, s)
+	}
+
+	n := strings.TrimRight(s, "kKmMgGtT")
+
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: could not parse bitrate: %s", ErrOverride, err)
+	}
+
+	return v * sizeUnits[strings.ToLower(s[len(n):])], nil
+}
+
+// overrideInt parses the parameter and checks that it is between 1 and limit.
+func overrideInt(q url.Values, name string, limit int) (int, bool, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, false, nil
+	}
+
+	if limit == 0 {
+		return 0, false, fmt.Errorf("%w: %s", ErrNotAllowed, name)
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s is no number", ErrOverride, name)
+	}
+
+	if err := validate.Var(v, fmt.Sprintf("min=1,max=%d", limit)); err != nil {
+		return 0, false, fmt.Errorf("%w: %s has to be between 1 and %d", ErrOverride, name, limit)
+	}
+
+	return v, true, nil
+}
+
+// overrideFlag parses the boolean parameter and checks that it is allowed.
+func overrideFlag(q url.Values, name string, l overrideLimits) (bool, bool, error) {
+	s := q.Get(name)
+	if s == "" {
+		return false, false, nil
+	}
+
+	if !l.allows(name) {
+		return false, false, fmt.Errorf("%w: %s", ErrNotAllowed, name)
+	}
+
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %s is no boolean", ErrOverride, name)
+	}
+
+	return v, true, nil
+}
+
+// checkEffect makes sure that overriding the parameter has an effect on the module. The
+// test duration of modules that transfer a fixed amount of data can not be overridden.
+// Neither can the protocol and bitrate of UDP capacity searches, nor options that a step of
+// the module sets itself.
+func checkEffect(m module, name string) error {
+	if name == "time" && m.countBased() {
+		return fmt.Errorf("%w: time has no effect, the module transfers a fixed amount of data", ErrOverride)
+	}
+
+	if (name == flagUDP || name == "bitrate") && m.UDPCapacity {
+		return fmt.Errorf("%w: %s has no effect, the module searches the udp capacity", ErrOverride, name)
+	}
+
+	for _, s := range m.Steps {
+		if name == "time" && s.Time != 0 ||
+			name == "parallel" && s.Parallel != 0 ||
+			name == "bitrate" && s.Bitrate != "" ||
+			name == flagUDP && s.Protocol != "" {
+			return fmt.Errorf("%w: %s has no effect, step %s sets its own", ErrOverride, name, s.Name)
+		}
+	}
+
+	return nil
+}
+
+// applyOverrides overrides the module options with the parameters of a probe request. Every
+// parameter is checked against the limits. Requests over the limits or with parameters
+// that have no effect on the module are rejected.
+func applyOverrides(m module, q url.Values, l overrideLimits) (module, error) {
+	if v, ok, err := overrideInt(q, "time", l.MaxTime); err != nil {
+		return module{}, err
+	} else if ok {
+		if err := checkEffect(m, "time"); err != nil {
+			return module{}, err
+		}
+
+		m.Time = v
+	}
+
+	if v, ok, err := overrideInt(q, "parallel", l.MaxParallel); err != nil {
+		return module{}, err
+	} else if ok {
+		if err := checkEffect(m, "parallel"); err != nil {
+			return module{}, err
+		}
+
+		m.Parallel = v
+	}
+
+	if s := q.Get("bitrate"); s != "" {
+		if l.MaxBitrate == "" {
+			return module{}, fmt.Errorf("%w: bitrate", ErrNotAllowed)
+		}
+
+		if err := checkEffect(m, "bitrate"); err != nil {
+			return module{}, err
+		}
+
+		v, err := parseBitrate(s)
+		if err != nil {
+			return module{}, err
+		}
+
+		// The limit got validated with the config.
+		limit, _ := parseBitrate(l.MaxBitrate)
+
+		if err := validate.Var(v, fmt.Sprintf("gt=0,lte=%f", limit)); err != nil {
+			return module{}, fmt.Errorf("%w: bitrate has to be at most %s", ErrOverride, l.MaxBitrate)
+		}
+
+		m.Bitrate = s
+	}
+
+	if v, ok, err := overrideFlag(q, flagUDP, l); err != nil {
+		return module{}, err
+	} else if ok {
+		if err := checkEffect(m, flagUDP); err != nil {
+			return module{}, err
+		}
+
+		m.UDP = v
+	}
+
+	if v, ok, err := overrideFlag(q, flagReverseOnly, l); err != nil {
+		return module{}, err
+	} else if ok {
+		m.ReverseOnly = v
+	}
+
+	return m, nil
+}
@@ -0,0 +1,132 @@
+package main //nolint:testpackage
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBitrate(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		s        string
+		expected float64
+		err      error
+	}{
+		{"001", "100", 100, nil},
+		{"002", "100M", 100e6, nil},
+		{"003", "1.5g", 1.5e9, nil},
+		{"004", "10Mbit", 0, ErrOverride},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+			v, err := parseBitrate(table.s)
+			require.ErrorIs(err, table.err)
+			require.Equal(table.expected, v)
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	t.Parallel()
+
+	limits := overrideLimits{
+		MaxTime:     30,
+		MaxParallel: 8,
+		MaxBitrate:  "100M",
+		Flags:       []string{flagUDP},
+	}
+
+	tables := []struct {
+		name     string
+		query    string
+		limits   overrideLimits
+		expected module
+		err      error
+	}{
+		{"001", "", limits, module{Time: 5}, nil},
+		{"002", "time=20&parallel=4", limits, module{Time: 20, Parallel: 4}, nil},
+		{"003", "time=60", limits, module{}, ErrOverride},
+		{"004", "time=0", limits, module{}, ErrOverride},
+		{"005", "parallel=foo", limits, module{}, ErrOverride},
+		{"006", "bitrate=50M&udp=true", limits, module{Time: 5, Bitrate: "50M", UDP: true}, nil},
+		{"007", "bitrate=1G", limits, module{}, ErrOverride},
+		{"008", "reverse_only=true", limits, module{}, ErrNotAllowed},
+		{"009", "time=10", overrideLimits{}, module{}, ErrNotAllowed},
+		{"010", "bitrate=10M", overrideLimits{}, module{}, ErrNotAllowed},
+		{
+			"011",
+			"reverse_only=1",
+			overrideLimits{Flags: []string{flagReverseOnly}},
+			module{Time: 5, ReverseOnly: true},
+			nil,
+		},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			q, err := url.ParseQuery(table.query)
+			require.NoError(err)
+
+			m, err := applyOverrides(module{Time: 5}, q, table.limits)
+			require.ErrorIs(err, table.err)
+			require.Equal(table.expected, m)
+		})
+	}
+}
+
+func TestApplyOverridesEffect(t *testing.T) {
+	t.Parallel()
+
+	limits := overrideLimits{
+		MaxTime:     30,
+		MaxParallel: 8,
+		MaxBitrate:  "100M",
+		Flags:       []string{flagUDP},
+	}
+
+	steps := []step{{Name: "tcp", Parallel: 4}, {Name: "voip", Protocol: protocolUDP, Bitrate: "1M"}}
+
+	tables := []struct {
+		name  string
+		query string
+		m     module
+		err   error
+	}{
+		{"001", "time=10", module{Bytes: "100M"}, ErrOverride},
+		{"002", "parallel=2", module{Bytes: "100M"}, nil},
+		{"003", "time=10", module{Time: 5, Steps: steps}, nil},
+		{"004", "parallel=2", module{Time: 5, Steps: steps}, ErrOverride},
+		{"005", "bitrate=10M", module{Time: 5, Steps: steps}, ErrOverride},
+		{"006", "udp=true", module{Time: 5, Steps: steps}, ErrOverride},
+		{"007", "time=10", module{Time: 5, Steps: []step{{Name: "short", Time: 2}}}, ErrOverride},
+		{"008", "udp=false", module{Time: 1, UDPCapacity: true}, ErrOverride},
+		{"009", "bitrate=10M", module{Time: 1, UDPCapacity: true}, ErrOverride},
+		{"010", "time=2", module{Time: 1, UDPCapacity: true}, nil},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			q, err := url.ParseQuery(table.query)
+			require.NoError(err)
+
+			_, err = applyOverrides(table.m, q, limits)
+			require.ErrorIs(err, table.err)
+		})
+	}
+}
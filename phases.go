@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Module phase orders.
+const (
+	orderDownloadFirst = "download-first"
+	orderUploadFirst   = "upload-first"
+	orderAlternate     = "alternate"
+)
+
+// phaseOverhead is the estimated time an iperf3 run needs on top of its test duration, for
+// connecting and exchanging the results.
+const phaseOverhead = 2 * time.Second
+
+// phase is a single iperf3 run of a probe. Module is the module of the run and Labels get
+// attached to all metrics of the phase.
+type phase struct {
+	Direction string
+	Timeout   time.Duration
+	Run       func(ctx context.Context, p probe, logger zerolog.Logger) (results, error)
+	Module    module
+	Labels    labels
+}
+
+// variant is a module to run the phases with and the labels that tell its results apart.
+type variant struct {
+	Module module
+	Labels labels
+}
+
+// variants returns the variants the phases of a probe run with. A module that compares
+// several congestion control algorithms has a variant for each of them. Every DSCP class of
+// the module gets its own variant as well.
+func (m module) variants() []variant {
+	vs := []variant{{Module: m}}
+
+	if len(m.Congestion) > 1 {
+		vs = expand(vs, "congestion", m.Congestion, func(m *module, v string) { m.Congestion = []string{v} })
+	}
+
+	if len(m.DSCP) > 0 {
+		vs = expand(vs, "dscp", m.DSCP, func(m *module, v string) { m.DSCP = []string{v} })
+	}
+
+	return vs
+}
+
+// expand returns a variant for every combination of the variants and the values. The value
+// is set on the module with set and attached as label with name.
+func expand(vs []variant, name string, values []string, set func(m *module, v string)) []variant {
+	expanded := make([]variant, 0, len(vs)*len(values))
+
+	for _, v := range vs {
+		for _, value := range values {
+			m := v.Module
+			set(&m, value)
+
+			expanded = append(expanded, variant{Module: m, Labels: v.Labels.with(name, value)})
+		}
+	}
+
+	return expanded
+}
+
+// alternations counts the probes per target, to switch the phase order with every probe.
+type alternations struct {
+	mu sync.Mutex
+	n  map[string]uint64
+}
+
+var alternation = &alternations{n: map[string]uint64{}} //nolint:gochecknoglobals
+
+// next returns the number of probes of the target so far and counts the current one.
+// Probes of unknown targets share a single count, so arbitrary `target` url parameters do
+// not grow the counts.
+func (a *alternations) next(p probe) uint64 {
+	name := ""
+	if p.Known {
+		name = p.Name
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := a.n[name]
+	a.n[name]++
+
+	return n
+}
+
+// phases returns the phases of a probe in the order they run. Every variant of the module
+// gets its own phases. Pipelines have a phase per step. UDP capacity searches always run a
+// download and an upload phase. Modules with ReverseOnly only keep the download phases. n
+// is the number of the probe, used by the alternate order.
+func (m module) phases(n uint64) []phase {
+	var ps []phase
+
+	for _, v := range m.variants() {
+		if len(m.Steps) != 0 {
+			ps = append(ps, stepPhases(v)...)
+
+			continue
+		}
+
+		if m.bidir() {
+			ps = append(ps, phase{Direction: directionBidir, Run: bidir, Module: v.Module, Labels: v.Labels})
+
+			continue
+		}
+
+		vps := []phase{
+			{Direction: "download", Timeout: m.DownloadTimeout, Run: download, Module: v.Module, Labels: v.Labels},
+			{Direction: "upload", Timeout: m.UploadTimeout, Run: upload, Module: v.Module, Labels: v.Labels},
+		}
+
+		if m.UDPCapacity {
+			vps[0].Run = capacityPhase("download", []string{"-R"})
+			vps[1].Run = capacityPhase("upload", []string{})
+		}
+
+		if m.Order == orderUploadFirst || (m.Order == orderAlternate && n%2 == 1) {
+			vps[0], vps[1] = vps[1], vps[0]
+		}
+
+		ps = append(ps, vps...)
+	}
+
+	if m.ReverseOnly {
+		return downloads(ps)
+	}
+
+	return ps
+}
+
+// downloads returns only the download phases.
+func downloads(ps []phase) []phase {
+	var d []phase
+
+	for _, ph := range ps {
+		if ph.Direction == "download" {
+			d = append(d, ph)
+		}
+	}
+
+	return d
+}
+
+// checkTime is the longest time the connect check and the idle latency measurement of the
+// module take before the first phase.
+func (m module) checkTime() time.Duration {
+	t := time.Duration(m.Connects) * connectTimeout
+
+	if m.LatencyUnderLoad {
+		t += idleConnects * connectTimeout
+	}
+
+	return t
+}
+
+// fitTime shortens the test duration of the module and its steps, so that the checks before
+// the first phase, all runs of all phases, their omitted seconds and the waits between the
+// phases fit into the timeout, before iperf3 gets interrupted. The test duration is never shortened below one second. Runs
+// that transfer a fixed amount of data are left as they are. It reports if a test duration
+// got shortened.
+func fitTime(m module, timeout time.Duration) (module, bool) {
+	ps := time.Duration(len(m.phases(0)))
+	n := ps * time.Duration(m.runs())
+
+	if m.Time == 0 || n == 0 || m.countBased() {
+		return m, false
+	}
+
+	budget := timeout - interruptLead - m.checkTime() - m.Wait*(ps-1)
+	perRun := budget/n - phaseOverhead - time.Duration(m.Omit)*time.Second
+	limit := int(perRun / time.Second)
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	shortened := false
+
+	if m.Time > limit {
+		m.Time = limit
+		shortened = true
+	}
+
+	steps := make([]step, len(m.Steps))
+
+	for i, s := range m.Steps {
+		if s.Time > limit {
+			s.Time = limit
+			shortened = true
+		}
+
+		steps[i] = s
+	}
+
+	if len(m.Steps) != 0 {
+		m.Steps = steps
+	}
+
+	return m, shortened
+}
+
+// wait waits for d or until the context is done.
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("could not wait: %w", ctx.Err())
+	case <-t.C:
+		return nil
+	}
+}
+
+// runPhases runs the phases of the probe against its target. A phase that timed out or
+// failed on its own gets reported as skipped and the next phase still runs. If the server
+// is unreachable, busy or refused the credentials, the probe fails right away, so a pool
+// can fail over in time. Otherwise the probe only fails if no phase succeeds.
+func runPhases(ctx context.Context, p probe, pm *probeMetrics, logger zerolog.Logger) error {
+	if p.Module.Connects != 0 {
+		logger.Info().Int("connects", p.Module.Connects).Msg("checking connect time")
+
+		if err := checkConnect(ctx, p, pm); err != nil {
+			return err
+		}
+	}
+
+	// Without latency under load, phases just run.
+	run := func(ctx context.Context, pm *probeMetrics, direction string, f func() error) error { return f() }
+
+	if p.Module.LatencyUnderLoad {
+		logger.Info().Msg("measuring idle latency")
+
+		// The latency target might be down while the iperf3 server is fine. The phases still
+		// run, just without the latency metrics.
+		if idle, err := measureIdle(ctx, p, pm); err != nil {
+			logger.Warn().Err(err).Msg("could not measure idle latency, skipping latency under load")
+		} else {
+			run = func(ctx context.Context, pm *probeMetrics, direction string, f func() error) error {
+				return underLoad(ctx, p, pm, direction, idle, f)
+			}
+		}
+	}
+
+	var (
+		lastErr   error
+		succeeded int
+	)
+
+	for i, ph := range p.Module.phases(p.Number) {
+		ph := ph
+		pp := p
+		pp.Module = ph.Module
+		ppm := pm.withLabels(ph.Labels)
+		l := labels{"direction": ph.Direction}
+
+		if i > 0 {
+			logger.Debug().Dur("wait", p.Module.Wait).Msg("waiting")
+
+			if err := wait(ctx, p.Module.Wait); err != nil && lastErr == nil {
+				lastErr = err
+			}
+		}
+
+		// Once the probe context is done, all remaining phases are skipped.
+		if ctx.Err() != nil {
+			logger.Warn().Str("direction", ph.Direction).Msg("no time left, skipping phase")
+			ppm.setWithLabels("iperf3_phase_skipped", l, 1)
+
+			continue
+		}
+
+		logger.Info().Str("direction", ph.Direction).Interface("labels", ph.Labels).Msg("getting metrics")
+
+		pctx, cancel := ctx, context.CancelFunc(func() {})
+		if ph.Timeout != 0 {
+			pctx, cancel = context.WithTimeout(ctx, ph.Timeout)
+		}
+
+		err := run(pctx, ppm, ph.Direction, func() error { return runRepetitions(pctx, ph, pp, ppm, logger) })
+
+		cancel()
+
+		if err != nil {
+			if serverError(err) {
+				return err
+			}
+
+			lastErr = err
+
+			logger.Warn().Err(err).Str("direction", ph.Direction).Msg("phase failed, skipping it")
+			ppm.setWithLabels("iperf3_phase_skipped", l, 1)
+
+			continue
+		}
+
+		succeeded++
+
+		ppm.setWithLabels("iperf3_phase_skipped", l, 0)
+	}
+
+	if succeeded == 0 {
+		return lastErr
+	}
+
+	return nil
+}
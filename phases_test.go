@@ -0,0 +1,96 @@
+package main //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModulePhases(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		m        module
+		n        uint64
+		expected []string
+	}{
+		{"001", module{}, 0, []string{"download", "upload"}},
+		{"002", module{Order: orderUploadFirst}, 0, []string{"upload", "download"}},
+		{"003", module{Order: orderAlternate}, 0, []string{"download", "upload"}},
+		{"004", module{Order: orderAlternate}, 1, []string{"upload", "download"}},
+		{"005", module{Order: orderAlternate, Direction: directionBidir}, 1, []string{"bidir"}},
+		{
+			"006",
+			module{Congestion: []string{"cubic", "bbr"}},
+			0,
+			[]string{"download cubic", "upload cubic", "download bbr", "upload bbr"},
+		},
+		{"007", module{Congestion: []string{"bbr"}}, 0, []string{"download", "upload"}},
+		{
+			"008",
+			module{Direction: directionBidir, Congestion: []string{"cubic", "bbr"}, DSCP: []string{"EF", "BE"}},
+			0,
+			[]string{"bidir cubic EF", "bidir cubic BE", "bidir bbr EF", "bidir bbr BE"},
+		},
+		{"009", module{Direction: directionBidir, ReverseOnly: true}, 0, []string{"download"}},
+		{"010", module{Order: orderUploadFirst, ReverseOnly: true}, 0, []string{"download"}},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+
+			var directions []string
+			for _, ph := range table.m.phases(table.n) {
+				d := ph.Direction
+				if cc, ok := ph.Labels["congestion"]; ok {
+					d += " " + cc
+					require.Equal(t, []string{cc}, ph.Module.Congestion)
+				}
+
+				if dscp, ok := ph.Labels["dscp"]; ok {
+					d += " " + dscp
+					require.Equal(t, []string{dscp}, ph.Module.DSCP)
+				}
+
+				directions = append(directions, d)
+			}
+
+			require.Equal(t, table.expected, directions)
+		})
+	}
+}
+
+func TestFitTime(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		m        module
+		timeout  time.Duration
+		expected int
+	}{
+		{"001", module{Time: 5, Wait: time.Second}, time.Minute, 5},
+		{"002", module{Time: 30, Wait: time.Second}, time.Minute, 27},
+		{"003", module{Time: 30, Direction: directionBidir}, 20 * time.Second, 17},
+		{"004", module{Time: 10, Wait: time.Second}, 3 * time.Second, 1},
+		{"005", module{Time: 10, Wait: time.Second, Repetitions: 3, Warmup: true}, time.Minute, 5},
+		{"006", module{Time: 30, Wait: time.Second, Omit: 3}, time.Minute, 24},
+		{"007", module{Time: 30, Congestion: []string{"cubic", "bbr"}}, time.Minute, 12},
+		{"008", module{Time: 30, Bytes: "100M"}, 10 * time.Second, 30},
+		{"009", module{Time: 30, Wait: time.Second, Connects: 3}, time.Minute, 24},
+		{"010", module{Time: 30, Wait: time.Second, Connects: 1, LatencyUnderLoad: true}, time.Minute, 21},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			m, _ := fitTime(table.m, table.timeout)
+			require.Equal(t, table.expected, m.Time)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/rs/zerolog"
+)
+
+// Pool strategies.
+const (
+	strategyOrdered = "ordered"
+	strategyRandom  = "random"
+	strategyAuto    = "auto"
+)
+
+// defaultCooldown is the time a failed pool server gets skipped if no cooldown is configured.
+const defaultCooldown = time.Minute
+
+var ErrNoServerAvailable = errors.New("no server available")
+
+// pool is a list of iperf3 servers a probe can choose from.
+type pool struct {
+	Name     string
+	Servers  []Target
+	Strategy string
+	Cooldown time.Duration
+}
+
+// serverHealth tracks which pool servers failed and until when they get skipped.
+type serverHealth struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// health is the global health state of all pool servers.
+var health = &serverHealth{until: map[string]time.Time{}} //nolint:gochecknoglobals
+
+// available reports if the server is not in its cooldown.
+func (h *serverHealth) available(server string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return time.Now().After(h.until[server])
+}
+
+// failed puts the server into cooldown.
+func (h *serverHealth) failed(server string, cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.until[server] = time.Now().Add(cooldown)
+}
+
+// succeeded ends the cooldown of the server.
+func (h *serverHealth) succeeded(server string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.until, server)
+}
+
+// serverFailed reports if the error of a probe against a pool server is a failure of the
+// server. Timeouts and cancellations of the probe itself, because its deadline passed or
+// prometheus closed the connection, are not. Neither are runs that were not started for
+// lack of time.
+func serverFailed(ctx context.Context, err error) bool {
+	if errors.Is(err, ErrNoTimeLeft) {
+		return false
+	}
+
+	return ctx.Err() == nil || !errors.Is(err, ctx.Err())
+}
+
+// register creates the health metrics of a pool server.
+func (pl *pool) register(server string) {
+	metrics.GetOrCreateGauge(
+		fmt.Sprintf(`iperf3_pool_server_available{pool=%q,server=%q}`, pl.Name, server),
+		func() float64 {
+			if health.available(server) {
+				return 1
+			}
+
+			return 0
+		},
+	)
+}
+
+// candidates returns the servers that are not in cooldown, ordered by the pool strategy.
+func (pl *pool) candidates() []Target {
+	servers := append([]Target{}, pl.Servers...)
+
+	if pl.Strategy == strategyRandom {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec
+		rnd.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+	}
+
+	var cs []Target
+
+	for _, s := range servers {
+		pl.register(s.String())
+
+		if health.available(s.String()) {
+			cs = append(cs, s)
+		}
+	}
+
+	return cs
+}
+
+// byConnectTime measures the TCP connect time to all servers at the same time. It returns
+// the reachable servers sorted by their connect time and the measured connect times.
+func byConnectTime(ctx context.Context, servers []Target) ([]Target, map[Target]time.Duration) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		rtts = map[Target]time.Duration{}
+	)
+
+	for _, s := range servers {
+		wg.Add(1)
+
+		go func(s Target) {
+			defer wg.Done()
+
+			rtt, err := connectTime(ctx, s)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			rtts[s] = rtt
+			mu.Unlock()
+		}(s)
+	}
+
+	wg.Wait()
+
+	reachable := make([]Target, 0, len(rtts))
+
+	for _, s := range servers {
+		if _, ok := rtts[s]; ok {
+			reachable = append(reachable, s)
+		}
+	}
+
+	sort.SliceStable(reachable, func(i, j int) bool { return rtts[reachable[i]] < rtts[reachable[j]] })
+
+	return reachable, rtts
+}
+
+// setConnectTimes sets the connect time and reachability of every measured candidate.
+func setConnectTimes(pm *probeMetrics, measured []Target, rtts map[Target]time.Duration) {
+	for _, c := range measured {
+		l := labels{"candidate": c.String()}
+
+		rtt, ok := rtts[c]
+		if !ok {
+			pm.setWithLabels("iperf3_auto_candidate_reachable", l, 0)
+
+			continue
+		}
+
+		pm.setWithLabels("iperf3_auto_candidate_reachable", l, 1)
+		pm.setWithLabels("iperf3_auto_connect_seconds", l, rtt.Seconds())
+	}
+}
+
+// runPool tries the pool servers one after another until a probe succeeds or the context
+// is done. Failed servers are skipped for the pool cooldown. The used server is attached
+// as `server` label. The `auto` strategy tries the servers with the lowest TCP connect
+// time first and skips unreachable ones.
+func runPool(ctx context.Context, p probe, logger zerolog.Logger) (*probeMetrics, error) {
+	err := ErrNoServerAvailable
+
+	cs := p.Pool.candidates()
+	measured := cs
+
+	var rtts map[Target]time.Duration
+
+	if p.Pool.Strategy == strategyAuto {
+		cs, rtts = byConnectTime(ctx, measured)
+
+		logger.Debug().Interface("candidates", cs).Msg("sorted candidates by connect time")
+	}
+
+	for _, s := range cs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		sp := p
+		sp.Target = s
+		pm := newProbeMetrics(p.Labels.with("server", s.String()))
+		sl := logger.With().Str("server", s.String()).Logger()
+
+		if rtts != nil {
+			setConnectTimes(pm, measured, rtts)
+		}
+
+		err = runPhases(ctx, sp, pm, sl)
+		if err == nil {
+			health.succeeded(s.String())
+			metrics.GetOrCreateCounter(
+				fmt.Sprintf(`iperf3_pool_server_successes{pool=%q,server=%q}`, p.Pool.Name, s),
+			).Inc()
+
+			return pm, nil
+		}
+
+		reason := failureReason(err)
+
+		if !serverFailed(ctx, err) {
+			sl.Warn().Err(err).Str("reason", reason).Msg("probe ended before the pool server finished")
+
+			break
+		}
+
+		sl.Warn().Err(err).Str("reason", reason).Msg("pool server failed")
+		health.failed(s.String(), p.Pool.Cooldown)
+		metrics.GetOrCreateCounter(
+			fmt.Sprintf(`iperf3_pool_server_failures{pool=%q,reason=%q,server=%q}`, p.Pool.Name, reason, s),
+		).Inc()
+	}
+
+	return nil, fmt.Errorf("pool %s: %w", p.Pool.Name, err)
+}
@@ -0,0 +1,84 @@
+package main //nolint:testpackage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolCandidates(t *testing.T) {
+	t.Parallel()
+
+	pl := &pool{
+		Name: "candidates",
+		Servers: []Target{
+			{"candidates1.tld", 5201},
+			{"candidates2.tld", 5201},
+			{"candidates3.tld", 5201},
+		},
+		Strategy: strategyOrdered,
+		Cooldown: time.Minute,
+	}
+
+	health.failed("candidates2.tld:5201", time.Minute)
+
+	require.Equal(t, []Target{{"candidates1.tld", 5201}, {"candidates3.tld", 5201}}, pl.candidates())
+
+	health.succeeded("candidates2.tld:5201")
+
+	require.Len(t, pl.candidates(), 3)
+}
+
+func TestByConnectTime(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	defer l.Close()
+
+	// A closed listener gives a port nothing listens on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	closed.Close()
+
+	reachable := Target{"127.0.0.1", l.Addr().(*net.TCPAddr).Port}
+	unreachable := Target{"127.0.0.1", closed.Addr().(*net.TCPAddr).Port}
+
+	cs, rtts := byConnectTime(context.Background(), []Target{unreachable, reachable})
+	require.Equal([]Target{reachable}, cs)
+	require.Contains(rtts, reachable)
+	require.NotContains(rtts, unreachable)
+}
+
+func TestServerFailed(t *testing.T) {
+	t.Parallel()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+
+	tables := []struct {
+		name     string
+		ctx      context.Context //nolint:containedctx
+		err      error
+		expected bool
+	}{
+		{"001", context.Background(), fmt.Errorf("download: %w", context.DeadlineExceeded), true},
+		{"002", expired, fmt.Errorf("download: %w", context.DeadlineExceeded), false},
+		{"003", canceled, fmt.Errorf("download: %w", context.Canceled), false},
+		{"004", canceled, fmt.Errorf("download: %w", ErrServerBusy), true},
+		{"005", context.Background(), fmt.Errorf("download: %w", ErrNoTimeLeft), false},
+	}
+
+	for _, table := range tables {
+		require.Equal(t, table.expected, serverFailed(table.ctx, table.err), table.name)
+	}
+}
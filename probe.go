@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probe is everything that is needed to run a single probe.
+type probe struct {
+	Name   string
+	Target Target
+	Pool   *pool
+	Module module
+	Labels labels
+
+	// Known is set if the probe is for a known target and not just for an address.
+	Known bool
+
+	// Number is the number of probes of the target so far, used by the alternate order.
+	Number uint64
+}
+
+// labels are prometheus labels that get attached to metrics.
+type labels map[string]string
+
+// labelValueReplacer escapes label values for the prometheus text format.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`) //nolint:gochecknoglobals
+
+// String formats the labels to be used in a metric name, sorted by name.
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for n := range l {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelValueReplacer.Replace(l[n])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// with returns a copy of the labels with name set to value.
+func (l labels) with(name, value string) labels {
+	n := make(labels, len(l)+1)
+	for k, v := range l {
+		n[k] = v
+	}
+
+	n[name] = value
+
+	return n
+}
+
+// probeMetrics holds the metrics of a single probe. Its labels get attached to every metric.
+// Probe metrics with additional labels share the values with the probe metrics they were
+// created from.
+type probeMetrics struct {
+	mu     *sync.Mutex
+	values map[string]float64
+	labels labels
+
+	// points are timestamped samples, by metric name with labels.
+	points map[string][]point
+
+	// legacy also sets the metrics with the legacy per direction names.
+	legacy bool
+}
+
+func newProbeMetrics(l labels) *probeMetrics {
+	return &probeMetrics{
+		mu:     &sync.Mutex{},
+		values: map[string]float64{},
+		points: map[string][]point{},
+		labels: l,
+		legacy: c.Exporter.LegacyMetrics,
+	}
+}
+
+// withLabels returns probe metrics that write into the same values, with the additional
+// labels attached to every metric.
+func (p *probeMetrics) withLabels(extra labels) *probeMetrics {
+	n := *p
+
+	for k, v := range extra {
+		n.labels = n.labels.with(k, v)
+	}
+
+	return &n
+}
+
+// set sets the metric with name to v.
+func (p *probeMetrics) set(name string, v float64) {
+	p.setWithLabels(name, nil, v)
+}
+
+// setWithLabels sets the metric with name and additional labels to v.
+func (p *probeMetrics) setWithLabels(name string, extra labels, v float64) {
+	l := p.labels
+
+	for k, val := range extra {
+		l = l.with(k, val)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.values[name+l.String()] = v
+}
+
+// legacyName is the family and the labels a legacy per direction metric got replaced with.
+type legacyName struct {
+	Family string
+	Labels labels
+}
+
+// legacyNames maps the legacy per direction metric names, without the `iperf3_<direction>_`
+// prefix, to their replacements.
+var legacyNames = map[string]legacyName{ //nolint:gochecknoglobals
+	"sent_bits_per_second":     {"iperf3_bits_per_second", labels{"role": "sender"}},
+	"received_bits_per_second": {"iperf3_bits_per_second", labels{"role": "receiver"}},
+	"sent_bytes":               {"iperf3_bytes", labels{"role": "sender"}},
+	"received_bytes":           {"iperf3_bytes", labels{"role": "receiver"}},
+	"sent_seconds":             {"iperf3_duration_seconds", labels{"role": "sender"}},
+	"received_seconds":         {"iperf3_duration_seconds", labels{"role": "receiver"}},
+	"sent_retransmits":         {"iperf3_retransmits", nil},
+}
+
+// setFamily sets the metric family of the direction with the labels to v.
+func (p *probeMetrics) setFamily(family, direction string, l labels, v float64) {
+	p.setWithLabels(family, l.with("direction", direction), v)
+}
+
+// setDirection sets the metric of the direction that had the legacy name to v. With legacy
+// metrics enabled, the legacy name gets set as well. It only gets the labels of the probe,
+// like the legacy metrics always had.
+func (p *probeMetrics) setDirection(direction, legacy string, l labels, v float64) {
+	n := legacyNames[legacy]
+	extra := l
+
+	for k, val := range n.Labels {
+		extra = extra.with(k, val)
+	}
+
+	p.setFamily(n.Family, direction, extra, v)
+
+	if p.legacy {
+		p.setWithLabels("iperf3_"+direction+"_"+legacy, nil, v)
+	}
+}
+
+// resultLabels returns the labels of an iperf3 result. Results of interrupted runs get a
+// `partial` label. The congestion control algorithm of the sender gets attached as
+// `congestion` label.
+func resultLabels(r iperfResult) labels {
+	l := labels{}
+	if r.Partial {
+		l["partial"] = "true"
+	}
+
+	if r.End.SenderTCPCongestion != "" {
+		l["congestion"] = r.End.SenderTCPCongestion
+	}
+
+	return l
+}
+
+// setResult sets all metrics of an iperf3 result for the direction, with the labels of the
+// result. If the run omitted its first seconds, the throughput with them is set as well.
+// UDP runs also set their jitter and loss. Results the server reported get set next to the
+// ones of the client. Results of UDP capacity searches only set the capacity metrics.
+func (p *probeMetrics) setResult(direction string, r iperfResult) {
+	if r.Capacity != nil {
+		p.setCapacity(direction, r)
+
+		return
+	}
+
+	l := resultLabels(r)
+
+	sent, received := r.End.SumSent, r.End.SumReceived
+
+	if r.udp() {
+		// Older iperf3 versions only report a single sum for UDP runs.
+		if sent.Seconds == 0 {
+			sent, received = r.End.Sum, r.End.Sum
+		}
+
+		p.setFamily("iperf3_jitter_seconds", direction, l, r.End.Sum.JitterMs/1000) //nolint:gomnd
+		p.setFamily("iperf3_lost_packets", direction, l, float64(r.End.Sum.LostPackets))
+		p.setFamily("iperf3_packets", direction, l, float64(r.End.Sum.Packets))
+		p.setFamily("iperf3_lost_percent", direction, l, r.End.Sum.LostPercent)
+	}
+
+	p.setDirection(direction, "sent_bits_per_second", l, sent.BitsPerSecond)
+	p.setDirection(direction, "sent_bytes", l, sent.Bytes)
+	p.setDirection(direction, "sent_seconds", l, sent.Seconds)
+
+	if !r.udp() {
+		p.setDirection(direction, "sent_retransmits", l, float64(sent.Retransmits))
+	}
+
+	p.setDirection(direction, "received_bits_per_second", l, received.BitsPerSecond)
+	p.setDirection(direction, "received_bytes", l, received.Bytes)
+	p.setDirection(direction, "received_seconds", l, received.Seconds)
+
+	if r.ServerOutput != nil {
+		cpu := r.End.CPUUtilizationPercent.HostTotal
+
+		p.setFamily("iperf3_cpu_utilization_percent", direction, l.with("side", "client"), cpu)
+		p.setServer(direction, l, *r.ServerOutput)
+	}
+
+	if omitted, bps := r.withOmitted(); omitted != 0 {
+		p.setFamily("iperf3_omitted_seconds", direction, l, omitted)
+		p.setFamily("iperf3_bits_per_second_with_omitted", direction, l, bps)
+	}
+}
+
+// setServer sets the metrics of the results the server reported.
+func (p *probeMetrics) setServer(direction string, l labels, r iperfResult) {
+	sender, receiver := l.with("role", "sender"), l.with("role", "receiver")
+	cpu := r.End.CPUUtilizationPercent.HostTotal
+
+	p.setFamily("iperf3_server_bits_per_second", direction, sender, r.End.SumSent.BitsPerSecond)
+	p.setFamily("iperf3_server_bytes", direction, sender, r.End.SumSent.Bytes)
+	p.setFamily("iperf3_server_bits_per_second", direction, receiver, r.End.SumReceived.BitsPerSecond)
+	p.setFamily("iperf3_server_bytes", direction, receiver, r.End.SumReceived.Bytes)
+	p.setFamily("iperf3_cpu_utilization_percent", direction, l.with("side", "server"), cpu)
+}
+
+// setCapacity sets the metrics of a UDP capacity search for the direction. The loss and
+// jitter are the ones of the run at the capacity.
+func (p *probeMetrics) setCapacity(direction string, r iperfResult) {
+	l := labels{"direction": direction}
+
+	p.setWithLabels("iperf3_udp_capacity_bits_per_second", l, r.Capacity.BitsPerSecond)
+	p.setWithLabels("iperf3_udp_capacity_lost_percent", l, r.End.Sum.LostPercent)
+	p.setWithLabels("iperf3_udp_capacity_jitter_seconds", l, r.End.Sum.JitterMs/1000) //nolint:gomnd
+	p.setWithLabels("iperf3_udp_capacity_runs", l, float64(r.Capacity.Runs))
+}
+
+// point is a sample with the time it was measured at.
+type point struct {
+	Time  time.Time
+	Value float64
+}
+
+// setIntervals adds the throughput of every interval of an iperf3 result for the direction
+// as timestamped samples, at the end of the interval. Omitted intervals are left out, like
+// in the results. Results of UDP capacity searches have no intervals.
+func (p *probeMetrics) setIntervals(direction string, r iperfResult) {
+	if r.Capacity != nil {
+		return
+	}
+
+	start := time.Unix(r.Start.Timestamp.Timesecs, 0)
+	l := p.labels
+
+	for k, v := range resultLabels(r).with("direction", direction) {
+		l = l.with(k, v)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, i := range r.Intervals {
+		if i.Sum.Omitted {
+			continue
+		}
+
+		role := "receiver"
+		if i.Sum.Sender {
+			role = "sender"
+		}
+
+		name := "iperf3_interval_bits_per_second" + l.with("role", role).String()
+		t := start.Add(time.Duration(i.Sum.End * float64(time.Second)))
+
+		p.points[name] = append(p.points[name], point{Time: t, Value: i.Sum.BitsPerSecond})
+	}
+}
+
+// write writes the probe metrics in the prometheus text format to w, sorted by name. The
+// timestamps of the timestamped samples are in the format.
+func (p *probeMetrics) write(w io.Writer, f format) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.values))
+	for n := range p.values {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		fmt.Fprintf(w, "%s %s\n", n, strconv.FormatFloat(p.values[n], 'g', -1, 64))
+	}
+
+	names = names[:0]
+	for n := range p.points {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		for _, pt := range p.points[n] {
+			fmt.Fprintf(w, "%s %s %s\n", n, strconv.FormatFloat(pt.Value, 'g', -1, 64), f.timestamp(pt.Time))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetResult(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	out := `{
+		"end": {
+			"sum_sent": {"seconds": 5, "bytes": 25000000, "bits_per_second": 40000000, "retransmits": 2, "sender": true},
+			"sum_received": {"seconds": 5, "bytes": 24000000, "bits_per_second": 38400000, "sender": true},
+			"cpu_utilization_percent": {"host_total": 12.5, "remote_total": 3.5},
+			"sender_tcp_congestion": "bbr"
+		},
+		"server_output_json": {
+			"end": {
+				"sum_sent": {"seconds": 5, "bytes": 0, "bits_per_second": 0},
+				"sum_received": {"seconds": 5, "bytes": 20000000, "bits_per_second": 32000000},
+				"cpu_utilization_percent": {"host_total": 3.5}
+			}
+		}
+	}`
+
+	var r iperfResult
+	require.NoError(json.Unmarshal([]byte(out), &r))
+
+	tables := []struct {
+		name     string
+		legacy   bool
+		expected []string
+		missing  []string
+	}{
+		{
+			"001",
+			false,
+			[]string{
+				`iperf3_bits_per_second{congestion="bbr",direction="upload",role="sender",site="berlin"} 4e+07`,
+				`iperf3_bytes{congestion="bbr",direction="upload",role="receiver",site="berlin"} 2.4e+07`,
+				`iperf3_retransmits{congestion="bbr",direction="upload",site="berlin"} 2`,
+				`iperf3_cpu_utilization_percent{congestion="bbr",direction="upload",side="client",site="berlin"} 12.5`,
+				`iperf3_cpu_utilization_percent{congestion="bbr",direction="upload",side="server",site="berlin"} 3.5`,
+				`iperf3_server_bytes{congestion="bbr",direction="upload",role="receiver",site="berlin"} 2e+07`,
+				`iperf3_server_bits_per_second{congestion="bbr",direction="upload",role="receiver",site="berlin"} 3.2e+07`,
+			},
+			[]string{`iperf3_upload_sent_bits_per_second`},
+		},
+		{
+			"002",
+			true,
+			[]string{
+				`iperf3_bits_per_second{congestion="bbr",direction="upload",role="sender",site="berlin"} 4e+07`,
+				`iperf3_upload_sent_bits_per_second{site="berlin"} 4e+07`,
+				`iperf3_upload_received_bytes{site="berlin"} 2.4e+07`,
+				`iperf3_upload_sent_retransmits{site="berlin"} 2`,
+			},
+			[]string{`iperf3_upload_client_cpu`, `iperf3_upload_server`},
+		},
+	}
+
+	for _, table := range tables {
+		pm := newProbeMetrics(labels{"site": "berlin"})
+		pm.legacy = table.legacy
+		pm.setResult("upload", r)
+
+		var b bytes.Buffer
+		pm.write(&b, formatText)
+
+		for _, line := range table.expected {
+			require.Contains(b.String(), line+"\n", table.name)
+		}
+
+		for _, name := range table.missing {
+			require.NotContains(b.String(), name, table.name)
+		}
+	}
+}
+
+func TestSetIntervals(t *testing.T) { //nolint:lll
+	t.Parallel()
+	require := require.New(t)
+
+	out := `{
+		"start": {"timestamp": {"timesecs": 1700000000}},
+		"intervals": [
+			{"sum": {"start": 0, "end": 1.000046, "bits_per_second": 1e+07, "omitted": true}},
+			{"sum": {"start": 0, "end": 1.000112, "bits_per_second": 4e+07}},
+			{"sum": {"start": 1.000112, "end": 2.0005, "bits_per_second": 4.2e+07}}
+		],
+		"end": {"sender_tcp_congestion": "bbr"}
+	}`
+
+	var r iperfResult
+	require.NoError(json.Unmarshal([]byte(out), &r))
+
+	tables := []struct {
+		name     string
+		format   format
+		expected string
+	}{
+		{
+			"001",
+			formatText,
+			`iperf3_interval_bits_per_second{congestion="bbr",direction="download",role="receiver",site="berlin"} 4e+07 1700000001000
+iperf3_interval_bits_per_second{congestion="bbr",direction="download",role="receiver",site="berlin"} 4.2e+07 1700000002000
+`,
+		},
+		{
+			"002",
+			formatOpenMetrics,
+			`iperf3_interval_bits_per_second{congestion="bbr",direction="download",role="receiver",site="berlin"} 4e+07 1700000001.000
+iperf3_interval_bits_per_second{congestion="bbr",direction="download",role="receiver",site="berlin"} 4.2e+07 1700000002.000
+`,
+		},
+	}
+
+	for _, table := range tables {
+		pm := newProbeMetrics(labels{"site": "berlin"})
+		pm.setIntervals("download", r)
+
+		var b bytes.Buffer
+		pm.write(&b, table.format)
+
+		require.Equal(table.expected, b.String(), table.name)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/rs/zerolog"
+)
+
+// repetitionQuantiles are the quantiles of the bits per second that get exported for
+// repeated runs. 0 is the minimum and 1 the maximum.
+var repetitionQuantiles = []float64{0, 0.5, 0.95, 1} //nolint:gochecknoglobals
+
+// repetitions returns the number of counted iperf3 runs of every phase.
+func (m module) repetitions() int {
+	if m.Repetitions == 0 {
+		return 1
+	}
+
+	return m.Repetitions
+}
+
+// runs returns the number of iperf3 runs of every phase, including the warm-up run and the
+// steps of UDP capacity searches.
+func (m module) runs() int {
+	n := m.repetitions()
+	if m.Warmup {
+		n++
+	}
+
+	if m.UDPCapacity {
+		n *= m.capacitySteps()
+	}
+
+	return n
+}
+
+// quantile returns the q quantile of the sorted values, interpolated linearly between the
+// closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	pos := q * float64(len(sorted)-1)
+	lower := math.Floor(pos)
+	upper := math.Ceil(pos)
+
+	return sorted[int(lower)] + (sorted[int(upper)]-sorted[int(lower)])*(pos-lower)
+}
+
+// setRepetitions sets the number of completed repetitions and the quantiles of their
+// received bits per second for the direction. For UDP capacity searches the quantiles are
+// of the capacity.
+func (p *probeMetrics) setRepetitions(direction string, rs []iperfResult) {
+	bps := make([]float64, 0, len(rs))
+
+	for _, r := range rs {
+		if r.Capacity != nil {
+			bps = append(bps, r.Capacity.BitsPerSecond)
+
+			continue
+		}
+
+		bps = append(bps, r.End.SumReceived.BitsPerSecond)
+	}
+
+	sort.Float64s(bps)
+
+	l := labels{"direction": direction}
+
+	p.setWithLabels("iperf3_repetitions_completed", l, float64(len(rs)))
+
+	if len(bps) == 0 {
+		return
+	}
+
+	for _, q := range repetitionQuantiles {
+		p.setWithLabels(
+			"iperf3_repetition_bits_per_second",
+			l.with("quantile", strconv.FormatFloat(q, 'f', -1, 64)),
+			quantile(bps, q),
+		)
+	}
+}
+
+// runRepetitions runs the phase as often as the module wants it to. The warm-up run gets
+// discarded. Repetitions stop once the context is done. The metrics of the last run are
+// set as usual, repeated runs also get their quantiles. Only results of complete runs
+// count as repetition. With intervals enabled, the intervals of every run get set. The
+// phase fails if no run succeeded, and right away if the server is unreachable, busy or
+// refused the credentials.
+func runRepetitions(ctx context.Context, ph phase, p probe, pm *probeMetrics, logger zerolog.Logger) error {
+	if p.Module.Warmup {
+		logger.Debug().Str("direction", ph.Direction).Msg("warm-up run")
+
+		if _, err := ph.Run(ctx, p, logger); err != nil {
+			if serverError(err) {
+				return err
+			}
+
+			logger.Warn().Err(err).Str("direction", ph.Direction).Msg("warm-up run failed")
+		}
+	}
+
+	var (
+		last      results
+		lastErr   error
+		completed = map[string][]iperfResult{}
+	)
+
+	for i := 0; i < p.Module.repetitions(); i++ {
+		if ctx.Err() != nil {
+			logger.Warn().Str("direction", ph.Direction).Int("completed", i).Msg("no time left for more repetitions")
+
+			break
+		}
+
+		rs, err := ph.Run(ctx, p, logger)
+		if err != nil {
+			if serverError(err) {
+				return err
+			}
+
+			lastErr = err
+
+			logger.Warn().Err(err).Str("direction", ph.Direction).Int("repetition", i).Msg("repetition failed")
+
+			continue
+		}
+
+		last = rs
+
+		for direction, r := range rs {
+			if p.Module.Intervals {
+				pm.setIntervals(direction, r)
+			}
+
+			if !r.Partial {
+				completed[direction] = append(completed[direction], r)
+			}
+		}
+	}
+
+	if last == nil {
+		return lastErr
+	}
+
+	for direction, r := range last {
+		pm.setResult(direction, r)
+
+		if p.Module.Repetitions > 1 {
+			pm.setRepetitions(direction, completed[direction])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantile(t *testing.T) {
+	t.Parallel()
+
+	tables := []struct {
+		name     string
+		sorted   []float64
+		q        float64
+		expected float64
+	}{
+		{"001", []float64{}, 0.5, 0},
+		{"002", []float64{10}, 0.95, 10},
+		{"003", []float64{10, 20, 30}, 0.5, 20},
+		{"004", []float64{10, 20, 30, 40}, 0.5, 25},
+		{"005", []float64{10, 20, 30, 40, 50}, 0.95, 48},
+		{"006", []float64{10, 20, 30}, 0, 10},
+		{"007", []float64{10, 20, 30}, 1, 30},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require.InDelta(t, table.expected, quantile(table.sorted, table.q), 0.0001)
+		})
+	}
+}
+
+func TestRunRepetitionsServerError(t *testing.T) {
+	t.Parallel()
+
+	errRun := errors.New("run failed")
+
+	tables := []struct {
+		name string
+		err  error
+		runs int
+	}{
+		{"001", ErrServerBusy, 1},
+		{"002", ErrUnreachable, 1},
+		{"003", ErrAuth, 1},
+		{"004", errRun, 4},
+	}
+
+	for _, table := range tables {
+		table := table
+		t.Run(table.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			var runs int
+
+			ph := phase{
+				Direction: "download",
+				Run: func(ctx context.Context, p probe, logger zerolog.Logger) (results, error) {
+					runs++
+
+					return nil, fmt.Errorf("could not get download metrics: %w", table.err)
+				},
+			}
+
+			p := probe{Module: module{Repetitions: 3, Warmup: true}}
+
+			err := runRepetitions(context.Background(), ph, p, newProbeMetrics(nil), zerolog.Nop())
+			require.ErrorIs(err, table.err)
+			require.Equal(table.runs, runs)
+		})
+	}
+}
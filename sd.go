@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.xsfx.dev/logginghandler"
+)
+
+// sdTargetGroup is a target group in the format of the prometheus http service discovery.
+type sdTargetGroup struct {
+	Targets []string `json:"targets"`
+	Labels  labels   `json:"labels"`
+}
+
+// sdTargetGroups creates a target group for every known target. host is the address
+// prometheus should use to scrape the exporter. The labels of the targets are left out,
+// /probe already attaches them to every metric.
+func sdTargetGroups(host string) []sdTargetGroup {
+	ts := targets.all()
+	groups := make([]sdTargetGroup, 0, len(ts))
+
+	for _, t := range ts {
+		l := labels{
+			"__metrics_path__": "/probe",
+			"__param_target":   t.Name,
+			"instance":         t.Name,
+		}
+
+		if t.Module != "" {
+			l["__param_module"] = t.Module
+		}
+
+		groups = append(groups, sdTargetGroup{
+			Targets: []string{host},
+			Labels:  l,
+		})
+	}
+
+	return groups
+}
+
+// sdHandler serves the known targets for the prometheus `http_sd_configs`.
+func sdHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logginghandler.Logger(r)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(sdTargetGroups(r.Host)); err != nil {
+		logger.Error().Err(err).Msg("could not encode target groups")
+	}
+}
@@ -0,0 +1,59 @@
+package main //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDHandler(t *testing.T) { //nolint:paralleltest
+	require := require.New(t)
+
+	targets.update(staticSource, []targetConfig{
+		{
+			Name:    "office",
+			Address: "iperf.office.tld:1234",
+			Module:  "lan",
+			Labels:  map[string]string{"site": "office"},
+		},
+		{
+			Name:    "wobcom",
+			Address: "speedtest.wobcom.de",
+		},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://192.168.39.191:9119/sd", nil)
+	w := httptest.NewRecorder()
+	sdHandler(w, r)
+
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var groups []sdTargetGroup
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &groups))
+	require.Equal(
+		[]sdTargetGroup{
+			{
+				Targets: []string{"192.168.39.191:9119"},
+				Labels: labels{
+					"__metrics_path__": "/probe",
+					"__param_target":   "office",
+					"__param_module":   "lan",
+					"instance":         "office",
+				},
+			},
+			{
+				Targets: []string{"192.168.39.191:9119"},
+				Labels: labels{
+					"__metrics_path__": "/probe",
+					"__param_target":   "wobcom",
+					"instance":         "wobcom",
+				},
+			},
+		},
+		groups,
+	)
+}
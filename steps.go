@@ -0,0 +1,87 @@
+package main
+
+import "time"
+
+// Step protocols.
+const (
+	protocolTCP = "tcp"
+	protocolUDP = "udp"
+)
+
+// step is a single test of a module pipeline. Unset options fall back to the module.
+type step struct {
+	Name      string        `validate:"required"`
+	Protocol  string        `validate:"omitempty,oneof=tcp udp"`
+	Direction string        `validate:"required,oneof=download upload bidir"`
+	Bitrate   string        `validate:"omitempty,iperf3_size"`
+	Parallel  int           `validate:"gte=0"`
+	Time      int           `validate:"gte=0"`
+	Timeout   time.Duration `validate:"gte=0"`
+}
+
+// module returns the module the step runs with.
+func (s step) module(m module) module {
+	switch s.Protocol {
+	case protocolTCP:
+		m.UDP = false
+	case protocolUDP:
+		m.UDP = true
+	}
+
+	if s.Bitrate != "" {
+		m.Bitrate = s.Bitrate
+	}
+
+	if s.Parallel != 0 {
+		m.Parallel = s.Parallel
+	}
+
+	if s.Time != 0 {
+		m.Time = s.Time
+	}
+
+	m.Direction = ""
+	if s.Direction == directionBidir {
+		m.Direction = directionBidir
+	}
+
+	m.Steps = nil
+
+	return m
+}
+
+// stepPhases returns a phase for every step of the variant, in the order of the steps. The
+// name of the step gets attached as `step` label.
+func stepPhases(v variant) []phase {
+	ps := make([]phase, 0, len(v.Module.Steps))
+
+	for _, s := range v.Module.Steps {
+		ph := phase{
+			Direction: s.Direction,
+			Timeout:   s.Timeout,
+			Module:    s.module(v.Module),
+			Labels:    v.Labels.with("step", s.Name),
+		}
+
+		switch s.Direction {
+		case "download":
+			ph.Run = download
+
+			if ph.Timeout == 0 {
+				ph.Timeout = v.Module.DownloadTimeout
+			}
+		case "upload":
+			ph.Run = upload
+
+			if ph.Timeout == 0 {
+				ph.Timeout = v.Module.UploadTimeout
+			}
+		case directionBidir:
+			ph.Run = bidir
+		}
+
+		ps = append(ps, ph)
+	}
+
+	return ps
+}
@@ -0,0 +1,69 @@
+package main //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepPhases(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	m := module{
+		Time:            10,
+		Parallel:        4,
+		DownloadTimeout: 20 * time.Second,
+		Steps: []step{
+			{Name: "tcp-down", Direction: "download"},
+			{Name: "tcp-up", Direction: "upload", Timeout: 15 * time.Second},
+			{Name: "udp-up", Protocol: protocolUDP, Direction: "upload", Bitrate: "5M", Parallel: 1, Time: 5},
+			{Name: "bidir", Direction: directionBidir},
+		},
+	}
+
+	ps := m.phases(1)
+	require.Len(ps, 4)
+
+	tables := []struct {
+		direction string
+		step      string
+		timeout   time.Duration
+		args      []string
+	}{
+		{"download", "tcp-down", 20 * time.Second, []string{"-t", "10", "-P", "4"}},
+		{"upload", "tcp-up", 15 * time.Second, []string{"-t", "10", "-P", "4"}},
+		{"upload", "udp-up", 0, []string{"-t", "5", "-P", "1", "-u", "-b", "5M"}},
+		{"bidir", "bidir", 0, []string{"-t", "10", "-P", "4", "--bidir"}},
+	}
+
+	for i, table := range tables {
+		require.Equal(table.direction, ps[i].Direction, table.step)
+		require.Equal(labels{"step": table.step}, ps[i].Labels, table.step)
+		require.Equal(table.timeout, ps[i].Timeout, table.step)
+		require.Equal(table.args, ps[i].Module.args(), table.step)
+	}
+}
+
+func TestFitTimeSteps(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	m := module{
+		Time: 5,
+		Steps: []step{
+			{Name: "tcp-down", Direction: "download"},
+			{Name: "udp-up", Protocol: protocolUDP, Direction: "upload", Time: 30},
+		},
+	}
+
+	fitted, shortened := fitTime(m, time.Minute)
+	require.True(shortened)
+	require.Equal(5, fitted.Time)
+	require.Equal(27, fitted.Steps[1].Time)
+	require.Equal(30, m.Steps[1].Time)
+
+	_, shortened = fitTime(m, 2*time.Minute)
+	require.False(shortened)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// targetConfig is a target that is defined in the config file. It gets selected by using
+// its name as `target` url parameter. A target either has an address or a pool of
+// addresses to choose from.
+type targetConfig struct {
+	Name     string        `validate:"required"`
+	Address  string        `validate:"required_without=Pool"`
+	Pool     []string      `validate:"required_without=Address,dive,required"`
+	Strategy string        `validate:"omitempty,oneof=ordered random auto"`
+	Cooldown time.Duration `validate:"gte=0"`
+	Module   string
+	Labels   map[string]string `validate:"dive,keys,label_name,unreserved_label_name,endkeys"`
+	Time     int               `validate:"gte=0"`
+	Parallel int               `validate:"gte=0"`
+	Auth     auth
+}
+
+// knownModule makes sure that the module is configured. No module means the defaults.
+func knownModule(name string, mods map[string]module) error {
+	if _, ok := mods[name]; name != "" && !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownModule, name)
+	}
+
+	return nil
+}
+
+// checkTargetModules makes sure that every module the targets refer to is configured.
+func checkTargetModules(ts []targetConfig, mods map[string]module) error {
+	for _, t := range ts {
+		if err := knownModule(t.Module, mods); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// staticSource is the registry source of the targets from the config file.
+const staticSource = "static"
+
+// targetRegistry holds all known targets grouped by the source they come from. Static
+// targets come from the config file, others get replaced by the discovery sources.
+type targetRegistry struct {
+	mu      sync.RWMutex
+	sources map[string][]targetConfig
+}
+
+// targets is the global target registry.
+var targets = &targetRegistry{sources: map[string][]targetConfig{}} //nolint:gochecknoglobals
+
+// update replaces all targets of a source.
+func (r *targetRegistry) update(source string, ts []targetConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sources[source] = ts
+}
+
+// all returns all targets. Static targets come first, the others are sorted by source.
+func (r *targetRegistry) all() []targetConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sources := make([]string, 0, len(r.sources))
+
+	for s := range r.sources {
+		if s != staticSource {
+			sources = append(sources, s)
+		}
+	}
+
+	sort.Strings(sources)
+
+	ts := append([]targetConfig{}, r.sources[staticSource]...)
+	for _, s := range sources {
+		ts = append(ts, r.sources[s]...)
+	}
+
+	return ts
+}
+
+// lookup returns the first target with the given name.
+func (r *targetRegistry) lookup(name string) (targetConfig, bool) {
+	for _, t := range r.all() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+
+	return targetConfig{}, false
+}
+
+// resolveProbe creates a probe out of the `target` and `module` url parameters. If trgt
+// matches the name of a known target, its address, module, labels and overrides
+// are used. Otherwise trgt gets used as address of the iperf3 server.
+func resolveProbe(trgt, moduleName string) (probe, error) {
+	tc, ok := targets.lookup(trgt)
+	if !ok {
+		tc = targetConfig{Name: trgt, Address: trgt}
+	}
+
+	if moduleName == "" {
+		moduleName = tc.Module
+	}
+
+	m, err := getModule(moduleName)
+	if err != nil {
+		return probe{}, err
+	}
+
+	if tc.Time != 0 {
+		m.Time = tc.Time
+	}
+
+	if tc.Parallel != 0 {
+		m.Parallel = tc.Parallel
+	}
+
+	m.Auth = tc.Auth.or(m.Auth)
+
+	if len(tc.Pool) != 0 {
+		pl, err := newPool(tc)
+		if err != nil {
+			return probe{}, err
+		}
+
+		return probe{
+			Name:   tc.Name,
+			Pool:   pl,
+			Module: m,
+			Labels: tc.Labels,
+			Known:  ok,
+		}, nil
+	}
+
+	t, err := NewTarget(tc.Address)
+	if err != nil {
+		return probe{}, err
+	}
+
+	return probe{
+		Name:   tc.Name,
+		Target: t,
+		Module: m,
+		Labels: tc.Labels,
+		Known:  ok,
+	}, nil
+}
+
+// newPool creates the server pool of a target.
+func newPool(tc targetConfig) (*pool, error) {
+	pl := &pool{
+		Name:     tc.Name,
+		Strategy: tc.Strategy,
+		Cooldown: tc.Cooldown,
+	}
+
+	if pl.Strategy == "" {
+		pl.Strategy = strategyOrdered
+	}
+
+	if pl.Cooldown == 0 {
+		pl.Cooldown = defaultCooldown
+	}
+
+	for _, a := range tc.Pool {
+		t, err := NewTarget(a)
+		if err != nil {
+			return nil, fmt.Errorf("could not determine pool server %s: %w", a, err)
+		}
+
+		pl.Servers = append(pl.Servers, t)
+	}
+
+	return pl, nil
+}
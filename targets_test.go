@@ -0,0 +1,141 @@
+package main //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProbe(t *testing.T) { //nolint:paralleltest
+	c.Iperf3.Time = 5
+	c.Modules = map[string]module{"lan": {Time: 10, Parallel: 2}}
+	targets.update(staticSource, []targetConfig{
+		{
+			Name:    "office",
+			Address: "iperf.office.tld:1234",
+			Module:  "lan",
+			Labels:  map[string]string{"site": "office", "isp": "foonet"},
+			Time:    20,
+		},
+		{
+			Name:     "public",
+			Pool:     []string{"speedtest.wobcom.de", "iperf.par2.as49434.net:9240"},
+			Strategy: "random",
+		},
+	})
+
+	tables := []struct {
+		name     string
+		trgt     string
+		module   string
+		expected probe
+		err      error
+	}{
+		{
+			"001",
+			"office",
+			"",
+			probe{
+				Name:   "office",
+				Target: Target{"iperf.office.tld", 1234},
+				Module: module{Time: 20, Parallel: 2},
+				Labels: labels{"site": "office", "isp": "foonet"},
+				Known:  true,
+			},
+			nil,
+		},
+		{
+			"002",
+			"foobar.tld",
+			"",
+			probe{Name: "foobar.tld", Target: Target{"foobar.tld", 5201}, Module: module{Time: 5}},
+			nil,
+		},
+		{
+			"003",
+			"foobar.tld",
+			"lan",
+			probe{Name: "foobar.tld", Target: Target{"foobar.tld", 5201}, Module: module{Time: 10, Parallel: 2}},
+			nil,
+		},
+		{
+			"004",
+			"public",
+			"",
+			probe{
+				Name: "public",
+				Pool: &pool{
+					Name: "public",
+					Servers: []Target{
+						{"speedtest.wobcom.de", 5201},
+						{"iperf.par2.as49434.net", 9240},
+					},
+					Strategy: "random",
+					Cooldown: time.Minute,
+				},
+				Module: module{Time: 5},
+				Known:  true,
+			},
+			nil,
+		},
+		{
+			"005",
+			"office",
+			"wan",
+			probe{},
+			ErrUnknownModule,
+		},
+	}
+
+	for _, table := range tables {
+		p, err := resolveProbe(table.trgt, table.module)
+		require.ErrorIs(t, err, table.err, table.name)
+		require.Equal(t, table.expected, p, table.name)
+	}
+}
+
+func TestCheckTargetModules(t *testing.T) {
+	t.Parallel()
+
+	mods := map[string]module{"lan": {Time: 10}}
+
+	tables := []struct {
+		name string
+		ts   []targetConfig
+		err  error
+	}{
+		{"001", []targetConfig{{Name: "office", Module: "lan"}, {Name: "wobcom"}}, nil},
+		{"002", []targetConfig{{Name: "office", Module: "lan"}, {Name: "wobcom", Module: "wan"}}, ErrUnknownModule},
+	}
+
+	for _, table := range tables {
+		require.ErrorIs(t, checkTargetModules(table.ts, mods), table.err, table.name)
+	}
+}
+
+func TestTargetLabelNames(t *testing.T) {
+	t.Parallel()
+
+	validate := newValidator()
+
+	tables := []struct {
+		name   string
+		labels map[string]string
+		valid  bool
+	}{
+		{"001", map[string]string{"site": "office"}, true},
+		{"002", map[string]string{"my-site": "office"}, false},
+		{"003", map[string]string{"direction": "upload"}, false},
+		{"004", map[string]string{"server": "iperf.tld"}, false},
+	}
+
+	for _, table := range tables {
+		err := validate.Struct(targetConfig{Name: "office", Address: "iperf.office.tld", Labels: table.labels})
+		if table.valid {
+			require.NoError(t, err, table.name)
+		} else {
+			require.Error(t, err, table.name)
+		}
+	}
+}